@@ -9,16 +9,17 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/caarlos0/env/v9"
 	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
 	"github.com/magnm/lcm/pkg/routes"
+	"github.com/samber/lo"
 	"golang.org/x/exp/slog"
 )
 
 // Run starts a chi http server
 func Run() {
-	cfg := config.Config{}
-	if err := env.Parse(&cfg); err != nil {
+	cfg, err := config.Load()
+	if err != nil {
 		slog.Error("failed to parse config", "err", err)
 		os.Exit(1)
 	}
@@ -31,11 +32,23 @@ func Run() {
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 
+	// Watch kubernetes resources, so long-polling requests are woken up on changes
+	informerStop := make(chan struct{})
+	defer close(informerStop)
+	go func() {
+		if err := kubernetes.StartInformers(informerStop); err != nil {
+			slog.Error("failed to start kubernetes informers", "err", err)
+		}
+	}()
+
+	// Leave room for wait_for_change requests to block until their timeout
+	writeTimeout := cfg.MaxWaitForChange + 10*time.Second
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.Port),
 		Handler:      router,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 	}
 
 	// Start the http server
@@ -53,7 +66,7 @@ func Run() {
 			Addr:         fmt.Sprintf(":%s", cfg.TlsPort),
 			Handler:      router,
 			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
+			WriteTimeout: writeTimeout,
 		}
 		go func() {
 			slog.Info("https server listening", "port", cfg.TlsPort)
@@ -64,6 +77,23 @@ func Run() {
 		}()
 	}
 
+	// Serve aws container credentials on a node-local address, where the sdks accept plain http
+	if address := cfg.Aws.ContainerCredentialsAddress; address != "" && lo.Contains(cfg.EnabledTypes(), config.AwsMetadata) {
+		credentialsSrv := &http.Server{
+			Addr:         address,
+			Handler:      routes.ContainerCredentialsRouter(),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		go func() {
+			slog.Info("container credentials server listening", "address", address)
+			if err := credentialsSrv.ListenAndServe(); err != nil {
+				slog.Info("server error", "err", err)
+				errChan <- err
+			}
+		}()
+	}
+
 	select {
 	case err := <-errChan:
 		slog.Error("server error", "err", err)
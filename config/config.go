@@ -1,9 +1,18 @@
 package config
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
 type MetadataType string
 
 const (
 	GoogleMetadata MetadataType = "google"
+	AwsMetadata    MetadataType = "aws"
+	AzureMetadata  MetadataType = "azure"
 )
 
 type KsaBindingResolver string
@@ -22,6 +31,7 @@ type Config struct {
 	TlsKey             string             `env:"TLS_KEY"`
 	Name               string             `env:"NAME" envDefault:"lc-metadata"`
 	Type               MetadataType       `env:"TYPE" envDefault:"google"`
+	Types              []MetadataType     `env:"TYPES" envSeparator:","`
 	LogLevel           string             `env:"LOG_LEVEL" envDefault:"info"`
 	ProjectId          string             `env:"PROJECT_ID,notEmpty"`
 	CloudKeyfile       string             `env:"CLOUD_KEYFILE"`
@@ -30,11 +40,123 @@ type Config struct {
 	LcmNamespace       string             `env:"LCM_NAMESPACE" envDefault:"kube-system"`
 	KsaResolver        KsaBindingResolver `env:"KSA_RESOLVER" envDefault:"annotation"`
 	KsaVerifyBinding   bool               `env:"KSA_VERIFY_BINDING" envDefault:"true"`
+	MaxWaitForChange   time.Duration      `env:"MAX_WAIT_FOR_CHANGE" envDefault:"5m"`
+	NodeName           string             `env:"NODE_NAME"`
+	ProjectAttributes  string             `env:"PROJECT_ATTRIBUTES_CONFIGMAP" envDefault:"lc-metadata-project-attributes"`
+	AdminEnabled       bool               `env:"ADMIN_ENABLED" envDefault:"false"`
+	AdminToken         string             `env:"ADMIN_TOKEN"`
+	Instance           Instance           `env:"INSTANCE"`
 	Google             Google             `env:"GOOGLE"`
+	Aws                Aws                `env:"AWS"`
+	Azure              Azure              `env:"AZURE"`
+}
+
+// EnabledTypes are the providers served, TYPES if set, else TYPE.
+func (c Config) EnabledTypes() []MetadataType {
+	if len(c.Types) > 0 {
+		return c.Types
+	}
+	return []MetadataType{c.Type}
+}
+
+// validate rejects instance settings that only make sense for one cloud when several providers are enabled,
+// as they would be served to all of them, e.g. a GCE machine type as the EC2 instance type.
+func (c Config) validate() error {
+	if len(c.EnabledTypes()) < 2 {
+		return nil
+	}
+
+	set := []string{}
+	for name, value := range map[string]bool{
+		"INSTANCE_ID":           c.Instance.Id != 0,
+		"INSTANCE_ZONE":         c.Instance.Zone != "",
+		"INSTANCE_NAME":         c.Instance.Name != "",
+		"INSTANCE_MACHINE_TYPE": c.Instance.MachineType != "",
+		"INSTANCE_IMAGE":        c.Instance.Image != "",
+	} {
+		if value {
+			set = append(set, name)
+		}
+	}
+	if len(set) > 0 {
+		sort.Strings(set)
+		return fmt.Errorf("%s cannot be set when several providers are enabled", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// Instance describes the machine and cluster reported to workloads.
+// Values left empty are derived from the environment lcm runs in.
+type Instance struct {
+	Id              uint64   `env:"INSTANCE_ID"`
+	Zone            string   `env:"INSTANCE_ZONE"`
+	ClusterLocation string   `env:"INSTANCE_CLUSTER_LOCATION"`
+	ClusterName     string   `env:"INSTANCE_CLUSTER_NAME"`
+	ClusterUid      string   `env:"INSTANCE_CLUSTER_UID"`
+	Network         string   `env:"INSTANCE_NETWORK" envDefault:"default"`
+	Name            string   `env:"INSTANCE_NAME"`
+	MachineType     string   `env:"INSTANCE_MACHINE_TYPE"`
+	Image           string   `env:"INSTANCE_IMAGE"`
+	Tags            []string `env:"INSTANCE_TAGS" envSeparator:","`
+	CpuPlatform     string   `env:"INSTANCE_CPU_PLATFORM"`
+	Description     string   `env:"INSTANCE_DESCRIPTION"`
 }
 
 type Google struct {
-	IdentityPool string `env:"GOOGLE_IDENTITY_POOL"`
+	IdentityPool   string `env:"GOOGLE_IDENTITY_POOL"`
+	UniverseDomain string `env:"GOOGLE_UNIVERSE_DOMAIN" envDefault:"googleapis.com"`
+
+	// CA used to issue workload certificates, the feature is disabled when unset
+	WorkloadCaCert       string        `env:"GOOGLE_WORKLOAD_CA_CERT"`
+	WorkloadCaKey        string        `env:"GOOGLE_WORKLOAD_CA_KEY"`
+	WorkloadCertLifetime time.Duration `env:"GOOGLE_WORKLOAD_CERT_LIFETIME" envDefault:"24h"`
+
+	// RSA key to sign identity tokens with locally, instead of through the IAM credentials api
+	IdentityTokenKey string `env:"GOOGLE_IDENTITY_TOKEN_KEY"`
+	// IdentityTokenIssuer is the iss claim of locally signed tokens, which cannot pass for tokens of Google
+	IdentityTokenIssuer string   `env:"GOOGLE_IDENTITY_TOKEN_ISSUER" envDefault:"https://lcm.io"`
+	LicenseIds          []string `env:"GOOGLE_LICENSE_IDS" envSeparator:","`
+}
+
+type Aws struct {
+	AccountId string `env:"AWS_ACCOUNT_ID" envDefault:"123456789012"`
+	Region    string `env:"AWS_REGION" envDefault:"us-east-1"`
+	// CredentialsFile is a shared credentials file with the main credentials of lcm, the default credential chain is used when unset
+	CredentialsFile string `env:"AWS_CREDENTIALS_FILE"`
+
+	// StsEndpoint overrides the endpoint roles are assumed through, e.g. for LocalStack
+	StsEndpoint     string        `env:"AWS_STS_ENDPOINT"`
+	SessionDuration time.Duration `env:"AWS_ROLE_SESSION_DURATION" envDefault:"1h"`
+
+	// HttpTokens is optional to allow IMDSv1 requests without a session token, or required to only allow IMDSv2
+	HttpTokens string `env:"AWS_HTTP_TOKENS" envDefault:"optional"`
+	// HopLimit for session token responses. Pods on the host network are one hop away, other pods two.
+	HopLimit int `env:"AWS_HTTP_PUT_RESPONSE_HOP_LIMIT" envDefault:"2"`
+
+	// ContainerCredentialsAddress is a node-local address to also serve the container credentials endpoint on, e.g. 169.254.170.23:80.
+	// The sdks only accept plain http for loopback addresses and the link-local addresses of ECS and EKS.
+	ContainerCredentialsAddress string `env:"AWS_CONTAINER_CREDENTIALS_ADDRESS"`
+	// ContainerCredentialsUri is where pods reach the container credentials endpoint, derived from the address by default.
+	// Container credentials are only handed out when either is set.
+	ContainerCredentialsUri string `env:"AWS_CONTAINER_CREDENTIALS_URI"`
+
+	// Certificate and RSA key to sign instance identity documents with, signatures are not served when unset
+	IdentityDocumentCert string `env:"AWS_IDENTITY_DOCUMENT_CERT"`
+	IdentityDocumentKey  string `env:"AWS_IDENTITY_DOCUMENT_KEY"`
+	// Certificate and 2048 bit RSA key of the separate rsa2048 signature, which is not served when unset
+	IdentityDocumentRsa2048Cert string `env:"AWS_IDENTITY_DOCUMENT_RSA2048_CERT"`
+	IdentityDocumentRsa2048Key  string `env:"AWS_IDENTITY_DOCUMENT_RSA2048_KEY"`
+}
+
+type Azure struct {
+	SubscriptionId string `env:"AZURE_SUBSCRIPTION_ID" envDefault:"00000000-0000-0000-0000-000000000000"`
+	ResourceGroup  string `env:"AZURE_RESOURCE_GROUP" envDefault:"lcm"`
+	TenantId       string `env:"AZURE_TENANT_ID"`
+
+	// TokenEndpoint exchanges ksa tokens for access tokens of the managed identity, {tenant} is replaced with the tenant id
+	TokenEndpoint string `env:"AZURE_TOKEN_ENDPOINT" envDefault:"https://login.microsoftonline.com/{tenant}/oauth2/v2.0/token"`
+	// TokenAudience of the ksa tokens, as configured in the federated credential of the identity
+	TokenAudience string `env:"AZURE_TOKEN_AUDIENCE" envDefault:"api://AzureADTokenExchange"`
 }
 
 // Initialised by server/run.go
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnabledTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []MetadataType
+	}{
+		{name: "type", config: Config{Type: GoogleMetadata}, want: []MetadataType{GoogleMetadata}},
+		{name: "types win", config: Config{Type: GoogleMetadata, Types: []MetadataType{AwsMetadata, AzureMetadata}}, want: []MetadataType{AwsMetadata, AzureMetadata}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.EnabledTypes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EnabledTypes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		types    []MetadataType
+		instance Instance
+		wantErr  string
+	}{
+		{name: "single provider with instance settings", instance: Instance{Id: 1, Zone: "europe-west1-b", MachineType: "e2-small"}},
+		{name: "several providers", types: []MetadataType{GoogleMetadata, AwsMetadata}},
+		{name: "several providers with shared settings", types: []MetadataType{GoogleMetadata, AwsMetadata}, instance: Instance{Network: "default", Tags: []string{"web"}}},
+		{
+			name:     "several providers with cloud specific settings",
+			types:    []MetadataType{GoogleMetadata, AwsMetadata},
+			instance: Instance{MachineType: "e2-small", Zone: "europe-west1-b"},
+			wantErr:  "INSTANCE_MACHINE_TYPE, INSTANCE_ZONE cannot be set when several providers are enabled",
+		},
+		{
+			name:     "several providers with every cloud specific setting",
+			types:    []MetadataType{AwsMetadata, AzureMetadata},
+			instance: Instance{Id: 1, Zone: "a", Name: "b", MachineType: "c", Image: "d"},
+			wantErr:  "INSTANCE_ID, INSTANCE_IMAGE, INSTANCE_MACHINE_TYPE, INSTANCE_NAME, INSTANCE_ZONE cannot be set when several providers are enabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Config{Type: GoogleMetadata, Types: tt.types, Instance: tt.instance}.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("err = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caarlos0/env/v9"
+)
+
+// ConfigFileEnv names the environment variable pointing to an optional config file.
+// The file holds KEY=value lines using the same keys as the environment,
+// e.g. a mounted ConfigMap. Values in the environment take precedence over the file.
+const ConfigFileEnv = "CONFIG_FILE"
+
+func Load() (Config, error) {
+	environment := map[string]string{}
+
+	if path := os.Getenv(ConfigFileEnv); path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		for key, value := range fileValues {
+			environment[key] = value
+		}
+	}
+	for _, pair := range os.Environ() {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			environment[key] = value
+		}
+	}
+
+	cfg := Config{}
+	if err := env.ParseWithOptions(&cfg, env.Options{Environment: environment}); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
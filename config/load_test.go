@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", content: "", want: map[string]string{}},
+		{
+			name:    "values",
+			content: "PROJECT_ID=my-project\nINSTANCE_ZONE=europe-west1-b\n",
+			want:    map[string]string{"PROJECT_ID": "my-project", "INSTANCE_ZONE": "europe-west1-b"},
+		},
+		{
+			name:    "comments and blank lines",
+			content: "# project\n\nPROJECT_ID=my-project\n   \n  # indented comment\n",
+			want:    map[string]string{"PROJECT_ID": "my-project"},
+		},
+		{
+			name:    "whitespace around keys and values",
+			content: "  PROJECT_ID = my-project  \n",
+			want:    map[string]string{"PROJECT_ID": "my-project"},
+		},
+		{
+			name:    "quoted values",
+			content: "A=\"double quoted\"\nB='single quoted'\nC=\"unbalanced'\nD=\"\"\n",
+			want:    map[string]string{"A": "double quoted", "B": "single quoted", "C": "\"unbalanced'", "D": ""},
+		},
+		{
+			name:    "equals sign in value",
+			content: "INSTANCE_DESCRIPTION=a=b\n",
+			want:    map[string]string{"INSTANCE_DESCRIPTION": "a=b"},
+		},
+		{
+			name:    "empty value",
+			content: "INSTANCE_NAME=\n",
+			want:    map[string]string{"INSTANCE_NAME": ""},
+		},
+		{
+			name:    "later lines win",
+			content: "PROJECT_ID=first\nPROJECT_ID=second\n",
+			want:    map[string]string{"PROJECT_ID": "second"},
+		},
+		{name: "line without equals sign", content: "PROJECT_ID=my-project\nINVALID\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.env")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readConfigFile = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileMissing(t *testing.T) {
+	if _, err := readConfigFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
@@ -0,0 +1,63 @@
+package certificates
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// DocumentSigner signs documents with a local rsa key, such as the identity documents of instances.
+type DocumentSigner struct {
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+func LoadDocumentSigner(certFile string, keyFile string) (*DocumentSigner, error) {
+	certificate, err := LoadCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("document signing key must be an rsa key")
+	}
+	if !rsaKey.PublicKey.Equal(certificate.PublicKey) {
+		return nil, errors.New("document signing key does not match the certificate")
+	}
+
+	return &DocumentSigner{key: rsaKey, certificate: certificate}, nil
+}
+
+// KeySize is the size of the rsa key in bits.
+func (s *DocumentSigner) KeySize() int {
+	return s.key.N.BitLen()
+}
+
+// Sign returns the SHA256 with RSA signature of the document.
+func (s *DocumentSigner) Sign(document []byte) ([]byte, error) {
+	sum := sha256.Sum256(document)
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+}
+
+// SignPkcs7 returns a detached, der encoded PKCS7 signature of the document.
+func (s *DocumentSigner) SignPkcs7(document []byte) ([]byte, error) {
+	signedData, err := pkcs7.NewSignedData(document)
+	if err != nil {
+		return nil, err
+	}
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signedData.AddSigner(s.certificate, s.key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	signedData.Detach()
+	return signedData.Finish()
+}
@@ -0,0 +1,190 @@
+package certificates
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/magnm/lcm/pkg/util"
+	"golang.org/x/exp/slog"
+)
+
+// Certificate is an issued workload certificate with its private key, both pem encoded.
+type Certificate struct {
+	CertificatePem string
+	PrivateKeyPem  string
+	NotBefore      time.Time
+	NotAfter       time.Time
+}
+
+// Issuer signs short-lived certificates for workload identities with a local CA.
+// Certificates are cached per identity, and renewed once half of their lifetime has passed.
+type Issuer struct {
+	caCert   *x509.Certificate
+	caKey    crypto.Signer
+	caPem    string
+	lifetime time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*Certificate
+
+	// Rotations is notified whenever a certificate is due for renewal
+	Rotations *util.Broadcaster
+}
+
+func LoadIssuer(certFile string, keyFile string, lifetime time.Duration) (*Issuer, error) {
+	caCert, err := LoadCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caKey, err := LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Issuer{
+		caCert:    caCert,
+		caKey:     caKey,
+		caPem:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+		lifetime:  lifetime,
+		cache:     map[string]*Certificate{},
+		Rotations: util.NewBroadcaster(),
+	}, nil
+}
+
+// TrustAnchorsPem returns the CA certificate workload certificates are verified against.
+func (i *Issuer) TrustAnchorsPem() string {
+	return i.caPem
+}
+
+func (i *Issuer) Lifetime() time.Duration {
+	return i.lifetime
+}
+
+// Issue returns a valid certificate for the spiffe id, issuing a new one when needed.
+func (i *Issuer) Issue(spiffeId string) (*Certificate, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	if cached, ok := i.cache[spiffeId]; ok && now.Before(renewAt(cached)) {
+		return cached, nil
+	}
+
+	certificate, err := i.sign(spiffeId, now)
+	if err != nil {
+		return nil, err
+	}
+	i.cache[spiffeId] = certificate
+
+	time.AfterFunc(renewAt(certificate).Sub(now), i.Rotations.Notify)
+	slog.Debug("issued workload certificate", "id", spiffeId, "expires", certificate.NotAfter)
+
+	return certificate, nil
+}
+
+func (i *Issuer) sign(spiffeId string, now time.Time) (*Certificate, error) {
+	uri, err := url.Parse(spiffeId)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := now.Add(-time.Minute)
+	notAfter := now.Add(i.lifetime)
+	if notAfter.After(i.caCert.NotAfter) {
+		notAfter = i.caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		URIs:                  []*url.URL{uri},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, i.caCert, key.Public(), i.caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		CertificatePem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPem:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})),
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+	}, nil
+}
+
+func renewAt(certificate *Certificate) time.Time {
+	return certificate.NotBefore.Add(certificate.NotAfter.Sub(certificate.NotBefore) / 2)
+}
+
+// LoadCertificate reads the first pem encoded certificate in the file.
+func LoadCertificate(certFile string) (*x509.Certificate, error) {
+	certPem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPem)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no pem certificate found in %s", certFile)
+	}
+	return x509.ParseCertificate(certBlock.Bytes)
+}
+
+// LoadPrivateKey reads a pem encoded PKCS8, EC or PKCS1 private key.
+func LoadPrivateKey(keyFile string) (crypto.Signer, error) {
+	keyPem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPem)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no pem key found in %s", keyFile)
+	}
+	return parsePrivateKey(keyBlock.Bytes)
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
@@ -0,0 +1,72 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/magnm/lcm/config"
+	"golang.org/x/exp/slog"
+)
+
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	ExpiresAt       time.Time
+}
+
+// AssumeRole gets temporary credentials for the role, using the main credentials of lcm.
+func AssumeRole(roleArn string, sessionName string) (*Credentials, error) {
+	slog.Debug("assuming aws role", "role", roleArn, "session", sessionName)
+	ctx := context.Background()
+
+	client, err := stsClient(ctx)
+	if err != nil {
+		slog.Error("failed to create sts client", "err", err)
+		return nil, err
+	}
+
+	output, err := client.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleArn),
+		RoleSessionName: aws.String(sessionName),
+		DurationSeconds: aws.Int32(int32(config.Current.Aws.SessionDuration.Seconds())),
+	})
+	if err != nil {
+		slog.Error("failed to assume role", "role", roleArn, "err", err)
+		return nil, err
+	}
+
+	return &Credentials{
+		AccessKeyId:     aws.ToString(output.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(output.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(output.Credentials.SessionToken),
+		ExpiresAt:       aws.ToTime(output.Credentials.Expiration),
+	}, nil
+}
+
+func stsClient(ctx context.Context) (*sts.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, authentication()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sts.NewFromConfig(cfg, func(options *sts.Options) {
+		if config.Current.Aws.StsEndpoint != "" {
+			options.BaseEndpoint = aws.String(config.Current.Aws.StsEndpoint)
+		}
+	}), nil
+}
+
+// authentication uses the default credential chain, or the credentials file given as AWS_CREDENTIALS_FILE
+func authentication() []func(*awsconfig.LoadOptions) error {
+	options := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(config.Current.Aws.Region),
+	}
+	if config.Current.Aws.CredentialsFile != "" {
+		options = append(options, awsconfig.WithSharedCredentialsFiles([]string{config.Current.Aws.CredentialsFile}))
+	}
+	return options
+}
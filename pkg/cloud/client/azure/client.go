@@ -0,0 +1,59 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	"golang.org/x/exp/slog"
+)
+
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// ExchangeToken gets an access token of the identity for the scope, using a federated token as client assertion,
+// the same exchange workload identity does on AKS.
+func ExchangeToken(tenantId string, clientId string, assertion string, scope string) (*Token, error) {
+	endpoint := strings.ReplaceAll(config.Current.Azure.TokenEndpoint, "{tenant}", tenantId)
+	slog.Debug("exchanging token for azure identity", "endpoint", endpoint, "clientId", clientId, "scope", scope)
+
+	response, err := httpClient.PostForm(endpoint, url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientId},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	token := tokenResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response with status %d: %w", response.StatusCode, err)
+	}
+	if response.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("token exchange failed with status %d: %s %s", response.StatusCode, token.Error, token.ErrorDescription)
+	}
+
+	return &Token{
+		AccessToken: token.AccessToken,
+		ExpiresAt:   time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
@@ -16,6 +16,7 @@ import (
 	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
 	durationpb "github.com/golang/protobuf/ptypes/duration"
 	"github.com/magnm/lcm/config"
+	"github.com/samber/lo"
 	"golang.org/x/exp/slog"
 	google "golang.org/x/oauth2/google"
 	"google.golang.org/api/oauth2/v1"
@@ -149,13 +150,18 @@ func GetMainAccountAccessToken() string {
 	return token.AccessToken
 }
 
-func GetServiceAccountToken(email string, scopes []string) *Token {
-	slog.Debug("getting service account token", "email", email, "scopes", scopes)
+// GetServiceAccountToken mints an access token of the account, impersonating it through the delegates in order, if any.
+func GetServiceAccountToken(email string, scopes []string, delegates []string) *Token {
+	slog.Debug("getting service account token", "email", email, "scopes", scopes, "delegates", delegates)
 	ctx := context.Background()
 
-	// Make sure we are allowed to generate tokens
-	if !verifyTokenCreatorOnServiceAccount(email) {
-		if err := selfGrantTokenCreatorOnServiceAccount(email); err != nil {
+	// Make sure we are allowed to generate tokens, with delegates we only need to impersonate the first one
+	firstHop := email
+	if len(delegates) > 0 {
+		firstHop = delegates[0]
+	}
+	if !verifyTokenCreatorOnServiceAccount(firstHop) {
+		if err := selfGrantTokenCreatorOnServiceAccount(firstHop); err != nil {
 			slog.Error("failed to grant token creator role on service account", "err", err)
 			return nil
 		}
@@ -173,7 +179,10 @@ func GetServiceAccountToken(email string, scopes []string) *Token {
 	}
 
 	token, err := client.GenerateAccessToken(ctx, &iamcredentialspb.GenerateAccessTokenRequest{
-		Name:  "projects/-/serviceAccounts/" + email,
+		Name: "projects/-/serviceAccounts/" + email,
+		Delegates: lo.Map(delegates, func(delegate string, i int) string {
+			return "projects/-/serviceAccounts/" + delegate
+		}),
 		Scope: scopes,
 		Lifetime: &durationpb.Duration{
 			Seconds: 3600,
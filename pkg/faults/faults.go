@@ -0,0 +1,91 @@
+package faults
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotation holds the faults to inject into metadata responses for a pod, as json, e.g.
+// {"latency":"500ms","unavailableRate":0.2,"tokenErrorRate":1,"longPollDelay":"30s","truncateRate":0.1}
+var Annotation = "lcm.io/faults"
+
+// Faults describes how responses to a pod are degraded.
+// Rates are probabilities between 0 and 1, evaluated per request.
+type Faults struct {
+	// Latency is added before every response
+	Latency Duration `json:"latency,omitempty"`
+	// ErrorRate of requests answered with a 500
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// UnavailableRate of requests answered with a 503
+	UnavailableRate float64 `json:"unavailableRate,omitempty"`
+	// TokenErrorRate of access and identity token requests answered with a 500
+	TokenErrorRate float64 `json:"tokenErrorRate,omitempty"`
+	// LongPollDelay is added before answering requests waiting for a change
+	LongPollDelay Duration `json:"longPollDelay,omitempty"`
+	// TruncateRate of responses cut off halfway through the body
+	TruncateRate float64 `json:"truncateRate,omitempty"`
+}
+
+// Duration is a time.Duration written as a string in json, e.g. "1.5s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if duration < 0 {
+		return errors.New("duration must not be negative")
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Parse reads and validates a faults definition.
+func Parse(data []byte) (*Faults, error) {
+	faults := &Faults{}
+	if err := json.Unmarshal(data, faults); err != nil {
+		return nil, err
+	}
+
+	rates := map[string]float64{
+		"errorRate":       faults.ErrorRate,
+		"unavailableRate": faults.UnavailableRate,
+		"tokenErrorRate":  faults.TokenErrorRate,
+		"truncateRate":    faults.TruncateRate,
+	}
+	for name, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+
+	return faults, nil
+}
+
+// ForPod returns the faults declared on the pod, or nil if there are none.
+func ForPod(pod *corev1.Pod) (*Faults, error) {
+	annotation, ok := pod.GetAnnotations()[Annotation]
+	if !ok {
+		return nil, nil
+	}
+	return Parse([]byte(annotation))
+}
+
+// Roll reports whether a fault with the given rate should happen for this request.
+func Roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,77 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/magnm/lcm/pkg/certificates"
+)
+
+// Signer issues RS256 signed jwts with a local key.
+type Signer struct {
+	key   *rsa.PrivateKey
+	keyId string
+}
+
+func LoadSigner(keyFile string) (*Signer, error) {
+	key, err := certificates.LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("jwt signing key must be an rsa key")
+	}
+
+	// The key id is derived from the public key, so it stays stable across restarts
+	publicDer, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(publicDer)
+
+	return &Signer{
+		key:   rsaKey,
+		keyId: hex.EncodeToString(sum[:20]),
+	}, nil
+}
+
+func (s *Signer) KeyId() string {
+	return s.keyId
+}
+
+func (s *Signer) Sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"kid": s.keyId,
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString(header),
+		base64.RawURLEncoding.EncodeToString(payload),
+	}, ".")
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,37 @@
+package kubernetes
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AttributeAnnotationPrefix is prepended to instance attribute keys declared on a pod,
+// e.g. lcm.io/attribute.startup-config
+var AttributeAnnotationPrefix = "lcm.io/attribute."
+
+// TagLabelPrefix marks node labels that become network tags of the instance,
+// e.g. lcm.io/tag.allow-ssh
+var TagLabelPrefix = "lcm.io/tag."
+
+// PodAnnotationsWithPrefix returns the annotations of the pod starting with prefix, keyed without it.
+func PodAnnotationsWithPrefix(pod *corev1.Pod, prefix string) map[string]string {
+	values := map[string]string{}
+	for key, value := range pod.GetAnnotations() {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// NodeLabelsWithPrefix returns the names of the labels of the node starting with prefix, without it.
+func NodeLabelsWithPrefix(node *corev1.Node, prefix string) []string {
+	names := []string{}
+	for key := range node.GetLabels() {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
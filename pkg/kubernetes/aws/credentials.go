@@ -0,0 +1,50 @@
+package aws
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/magnm/lcm/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ContainerCredentialsTokenKey is the key of the authorization token in the secret of a pod
+var ContainerCredentialsTokenKey = "token"
+
+// ContainerCredentialsSecretName derives the name of the secret holding the token from the token itself,
+// so the endpoint can find the pod a token belongs to.
+func ContainerCredentialsSecretName(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return kubernetes.OwnedSecretPrefix + "aws-credentials-" + hex.EncodeToString(sum[:8])
+}
+
+// CreateContainerCredentialsSecret creates a secret with a new authorization token for the container credentials endpoint,
+// returning its name. On dry runs the name is returned without creating the secret.
+func CreateContainerCredentialsSecret(namespace string, dryRun bool) (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(data)
+	name := ContainerCredentialsSecretName(token)
+
+	if dryRun {
+		return name, nil
+	}
+
+	return name, kubernetes.CreateSecret(&corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    kubernetes.OwnedSecretLabels(),
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			ContainerCredentialsTokenKey: token,
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package aws
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RoleArnAnnotation binds a ksa to an IAM role, as with IAM roles for service accounts on EKS
+var RoleArnAnnotation = "eks.amazonaws.com/role-arn"
+
+var roleArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/(?:[\w+=,.@-]+/)*([\w+=,.@-]{1,64})$`)
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+func GetRoleArnForKsa(ksa *corev1.ServiceAccount) string {
+	arn, ok := ksa.GetAnnotations()[RoleArnAnnotation]
+	if !ok {
+		slog.Error("no role arn annotation found on ksa", "ksa", ksa.Name)
+		return ""
+	}
+
+	if !roleArn.MatchString(arn) {
+		slog.Error("invalid role arn annotation on ksa", "ksa", ksa.Name, "arn", arn)
+		return ""
+	}
+
+	return arn
+}
+
+// RoleName returns the name of the role, without its path.
+func RoleName(arn string) string {
+	match := roleArn.FindStringSubmatch(arn)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// SessionNameForPod names the role session after the pod, so it can be recognized in CloudTrail.
+func SessionNameForPod(pod *corev1.Pod) string {
+	name := invalidSessionNameChars.ReplaceAllString("lcm-"+pod.Namespace+"-"+pod.Name, "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return strings.TrimRight(name, "-")
+}
@@ -0,0 +1,28 @@
+package azure
+
+import (
+	"github.com/magnm/lcm/config"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotations of azure workload identity, binding a ksa to a managed identity
+var ClientIdAnnotation = "azure.workload.identity/client-id"
+var TenantIdAnnotation = "azure.workload.identity/tenant-id"
+
+func GetClientIdForKsa(ksa *corev1.ServiceAccount) string {
+	clientId, ok := ksa.GetAnnotations()[ClientIdAnnotation]
+	if !ok {
+		slog.Error("no client id annotation found on ksa", "ksa", ksa.Name)
+		return ""
+	}
+	return clientId
+}
+
+// GetTenantIdForKsa returns the tenant of the identity, from the ksa or else the config.
+func GetTenantIdForKsa(ksa *corev1.ServiceAccount) string {
+	if tenantId, ok := ksa.GetAnnotations()[TenantIdAnnotation]; ok {
+		return tenantId
+	}
+	return config.Current.Azure.TenantId
+}
@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	kubeclient "github.com/magnm/lcm/pkg/kubernetes/client"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	errorv1 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// k3d names its nodes k3d-<cluster>-server-0, k3d-<cluster>-agent-0
+var k3dNodeName = regexp.MustCompile(`^k3d-(.+)-(server|agent)-\d+$`)
+
+// K3sInstanceType is the instance type label k3s puts on its nodes
+var K3sInstanceType = "k3s"
+
+var clusterUidLock sync.Mutex
+var clusterUid string
+
+var clusterNameLock sync.Mutex
+var clusterName *string
+
+// ClusterUid identifies the cluster by the UID of the kube-system namespace,
+// which is created once with the cluster and never changes.
+func ClusterUid() (string, error) {
+	clusterUidLock.Lock()
+	defer clusterUidLock.Unlock()
+	if clusterUid != "" {
+		return clusterUid, nil
+	}
+
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return "", err
+	}
+	namespace, err := client.CoreV1().Namespaces().Get(context.Background(), metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	clusterUid = string(namespace.UID)
+	return clusterUid, nil
+}
+
+// ClusterName detects the name of the cluster from the kubeadm configuration (which kind uses as well),
+// or from the naming of k3d nodes. Plain k3s clusters are named k3s.
+// An empty name is returned when the distribution is unknown. Once detection succeeds, its result is kept.
+func ClusterName() string {
+	clusterNameLock.Lock()
+	defer clusterNameLock.Unlock()
+	if clusterName != nil {
+		return *clusterName
+	}
+
+	name, err := kubeadmClusterName()
+	if err == nil && name == "" {
+		name, err = k3sClusterName()
+	}
+	if err != nil {
+		slog.Warn("failed to detect cluster name, retrying on next use", "err", err)
+		return ""
+	}
+
+	slog.Debug("detected cluster name", "name", name)
+	clusterName = &name
+	return name
+}
+
+func kubeadmClusterName() (string, error) {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return "", err
+	}
+	configMap, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.Background(), "kubeadm-config", metav1.GetOptions{})
+	if errorv1.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var clusterConfiguration struct {
+		ClusterName string `json:"clusterName"`
+	}
+	if err := yaml.Unmarshal([]byte(configMap.Data["ClusterConfiguration"]), &clusterConfiguration); err != nil {
+		slog.Error("failed to parse kubeadm cluster configuration", "err", err)
+		return "", nil
+	}
+	return clusterConfiguration.ClusterName, nil
+}
+
+func k3sClusterName() (string, error) {
+	nodes, err := listNodes()
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes {
+		if match := k3dNodeName.FindStringSubmatch(node.Name); match != nil {
+			return match[1], nil
+		}
+	}
+	for _, node := range nodes {
+		if node.Labels[corev1.LabelInstanceTypeStable] == K3sInstanceType {
+			return K3sInstanceType, nil
+		}
+	}
+	return "", nil
+}
+
+func listNodes() ([]*corev1.Node, error) {
+	if factory := informerFactory(); factory != nil {
+		return factory.Core().V1().Nodes().Lister().List(labels.Everything())
+	}
+
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	nodeList, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*corev1.Node{}
+	for i := range nodeList.Items {
+		nodes = append(nodes, &nodeList.Items[i])
+	}
+	return nodes, nil
+}
+
+// NodeLabels formats the labels of the node as a sorted, comma separated list of key=value pairs.
+func NodeLabels(node *corev1.Node) string {
+	pairs := []string{}
+	for key, value := range node.GetLabels() {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
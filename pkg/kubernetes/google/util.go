@@ -9,13 +9,81 @@ import (
 	"github.com/magnm/lcm/config"
 	googleclient "github.com/magnm/lcm/pkg/cloud/client/google"
 	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/samber/lo"
 	"golang.org/x/exp/slog"
 	corev1 "k8s.io/api/core/v1"
 )
 
 var GCPServiceAccountAnnotation = "iam.gke.io/gcp-service-account"
+
+// AdditionalGCPServiceAccountsAnnotation lists other service accounts a ksa may use, comma separated
+var AdditionalGCPServiceAccountsAnnotation = "lcm.io/additional-gcp-service-accounts"
+
+// GCPDelegatesAnnotation lists the service accounts impersonated in order to reach the accounts of a ksa, comma separated
+var GCPDelegatesAnnotation = "lcm.io/gcp-delegates"
 var MetadataServerDomain = "metadata.google.internal"
 
+// Simulated host events, set on a pod or on the node running it
+var MaintenanceEventAnnotation = "lcm.io/maintenance-event"
+var PreemptedAnnotation = "lcm.io/preempted"
+
+// GuestAttributesAnnotation holds the guest attributes written by a pod, as json namespace -> key -> value
+var GuestAttributesAnnotation = "lcm.io/guest-attributes"
+
+var MaintenanceEvents = []string{"NONE", "MIGRATE_ON_HOST_MAINTENANCE", "TERMINATE_ON_HOST_MAINTENANCE"}
+var PreemptedValues = []string{"FALSE", "TRUE"}
+
+// UsesDefaultAccount reports whether the pod runs as the default ksa,
+// and should get the default account configured for lcm.
+func UsesDefaultAccount(pod *corev1.Pod) bool {
+	return (pod.Spec.ServiceAccountName == "" ||
+		pod.Spec.ServiceAccountName == "default") &&
+		config.Current.DefaultAccount != ""
+}
+
+// ServiceAccountsForKsa resolves the permitted service accounts bound to the ksa, the primary one first.
+// No accounts are returned if the primary one is missing or not permitted.
+func ServiceAccountsForKsa(ksa *corev1.ServiceAccount) []string {
+	var email string
+	additional := []string{}
+
+	switch config.Current.KsaResolver {
+	case config.KsaBindingResolverAnnotation:
+		slog.Debug("using annotation to resolve ksa binding", "ksa", ksa)
+
+		email = GetGsaForKsa(ksa)
+		if email == "" {
+			slog.Error("no google service account binding found for ksa", "ksa", ksa)
+		} else {
+			additional = GetAdditionalGsasForKsa(ksa)
+		}
+	case config.KsaBindingResolverCRD:
+		slog.Error("using CRD to resolve ksa binding is not implemented", "ksa", ksa)
+	}
+
+	// Verify that this service account is permitted to be used
+	if !googleclient.IsServiceAccountPermitted(email) {
+		slog.Error("service account is not permitted", "ksa", ksa, "gsa", email)
+		return []string{}
+	}
+	if email == "" {
+		return []string{}
+	}
+
+	emails := []string{email}
+	for _, other := range additional {
+		if other == email {
+			continue
+		}
+		if !googleclient.IsServiceAccountPermitted(other) {
+			slog.Error("additional service account is not permitted", "ksa", ksa, "gsa", other)
+			continue
+		}
+		emails = append(emails, other)
+	}
+	return emails
+}
+
 func GetGsaForKsa(ksa *corev1.ServiceAccount) string {
 	gcpServiceAccount, ok := ksa.GetAnnotations()[GCPServiceAccountAnnotation]
 
@@ -24,9 +92,49 @@ func GetGsaForKsa(ksa *corev1.ServiceAccount) string {
 		return ""
 	}
 
+	if !verifyKsaGsaBinding(ksa, gcpServiceAccount) {
+		return ""
+	}
+
+	return gcpServiceAccount
+}
+
+// GetAdditionalGsasForKsa returns the other service accounts the ksa may use,
+// besides the one bound through GCPServiceAccountAnnotation. Each one must be bound to the ksa as well.
+func GetAdditionalGsasForKsa(ksa *corev1.ServiceAccount) []string {
+	annotation, ok := ksa.GetAnnotations()[AdditionalGCPServiceAccountsAnnotation]
+	if !ok {
+		return []string{}
+	}
+
+	accounts := []string{}
+	for _, gcpServiceAccount := range strings.Split(annotation, ",") {
+		gcpServiceAccount = strings.TrimSpace(gcpServiceAccount)
+		if gcpServiceAccount == "" || lo.Contains(accounts, gcpServiceAccount) {
+			continue
+		}
+		if verifyKsaGsaBinding(ksa, gcpServiceAccount) {
+			accounts = append(accounts, gcpServiceAccount)
+		}
+	}
+	return accounts
+}
+
+// GetDelegatesForKsa returns the delegation chain tokens of the accounts of the ksa are minted through, if any.
+func GetDelegatesForKsa(ksa *corev1.ServiceAccount) []string {
+	delegates := []string{}
+	for _, delegate := range strings.Split(ksa.GetAnnotations()[GCPDelegatesAnnotation], ",") {
+		if delegate = strings.TrimSpace(delegate); delegate != "" {
+			delegates = append(delegates, delegate)
+		}
+	}
+	return delegates
+}
+
+func verifyKsaGsaBinding(ksa *corev1.ServiceAccount, gcpServiceAccount string) bool {
 	if !config.Current.KsaVerifyBinding {
 		slog.Debug("found gsa annotation on ksa", "ksa", ksa.Name, "gsa", gcpServiceAccount)
-		return gcpServiceAccount
+		return true
 	}
 
 	// Validate that the ksa is bound to the gsa
@@ -42,7 +150,7 @@ func GetGsaForKsa(ksa *corev1.ServiceAccount) string {
 	if config.Current.Google.IdentityPool != "" {
 		project := googleclient.GetProject(config.Current.ProjectId)
 		if project == nil {
-			return ""
+			return false
 		}
 		numericId := strings.TrimPrefix(project.Name, "projects/")
 
@@ -56,10 +164,10 @@ func GetGsaForKsa(ksa *corev1.ServiceAccount) string {
 
 	if !googleclient.ValidateKsaGsaBinding(ksaBinding, gcpServiceAccount) {
 		slog.Error("ksa is not bound to the gsa", "ksa", ksa.Name, "gsa", gcpServiceAccount)
-		return ""
+		return false
 	}
 
-	return gcpServiceAccount
+	return true
 }
 
 func ShouldAddImagePullSecret(image reference.Named) bool {
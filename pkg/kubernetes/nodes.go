@@ -0,0 +1,106 @@
+package kubernetes
+
+import (
+	"context"
+	"hash/fnv"
+	"net/netip"
+	"strings"
+
+	"github.com/magnm/lcm/config"
+	kubeclient "github.com/magnm/lcm/pkg/kubernetes/client"
+	corev1 "k8s.io/api/core/v1"
+	errorv1 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var ZoneLabel = corev1.LabelTopologyZone
+var RegionLabel = corev1.LabelTopologyRegion
+
+func GetNode(name string) (*corev1.Node, error) {
+	if factory := informerFactory(); factory != nil {
+		return factory.Core().V1().Nodes().Lister().Get(name)
+	}
+
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func NodeForPod(pod *corev1.Pod) (*corev1.Node, error) {
+	if pod.Spec.NodeName == "" {
+		return nil, errorv1.NewNotFound(corev1.Resource("Node"), pod.Name)
+	}
+	return GetNode(pod.Spec.NodeName)
+}
+
+// ZoneOfNode returns the zone from the topology labels of the node.
+// Nodes labelled with only a region return the region, as there is no telling which of its zones exist.
+func ZoneOfNode(node *corev1.Node) string {
+	if zone := node.Labels[ZoneLabel]; zone != "" {
+		return zone
+	}
+	return node.Labels[RegionLabel]
+}
+
+// OurNode returns the node lcm itself runs on, as given by NODE_NAME.
+func OurNode() (*corev1.Node, error) {
+	if config.Current.NodeName == "" {
+		return nil, errorv1.NewNotFound(corev1.Resource("Node"), "NODE_NAME")
+	}
+	return GetNode(config.Current.NodeName)
+}
+
+// RegionOfZone strips the zone suffix, e.g. europe-west1-b becomes europe-west1.
+// Values that do not look like a zone are returned as-is.
+func RegionOfZone(zone string) string {
+	if strings.Count(zone, "-") < 2 {
+		return zone
+	}
+	return zone[:strings.LastIndex(zone, "-")]
+}
+
+// NumericId derives a stable positive number from an identifier such as a UID,
+// for clouds that use numeric ids.
+func NumericId(id string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(id)) //nolint:errcheck
+	return hash.Sum64() & (1<<63 - 1)
+}
+
+func NodeAddress(node *corev1.Node, addressType corev1.NodeAddressType) string {
+	if node == nil {
+		return ""
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// PodSubnet returns the pod CIDR of the node containing the address.
+// Pods outside of the node's ranges, e.g. on the host network, get a subnet around their address.
+func PodSubnet(node *corev1.Node, addr netip.Addr) netip.Prefix {
+	if node != nil {
+		cidrs := node.Spec.PodCIDRs
+		if len(cidrs) == 0 && node.Spec.PodCIDR != "" {
+			cidrs = []string{node.Spec.PodCIDR}
+		}
+		for _, cidr := range cidrs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err == nil && prefix.Contains(addr) {
+				return prefix.Masked()
+			}
+		}
+	}
+
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+	return netip.PrefixFrom(addr, bits).Masked()
+}
@@ -0,0 +1,222 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kubeclient "github.com/magnm/lcm/pkg/kubernetes/client"
+	"github.com/samber/lo"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	errorv1 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// OwnedSecretAnnotation names a secret the webhook created for the pod.
+// The secret is deleted together with the pod.
+var OwnedSecretAnnotation = "lcm.io/owned-secret"
+
+// Owned secrets are labelled as managed by lcm and named with OwnedSecretPrefix,
+// so that the annotation of a pod can never get lcm to delete or trust any other secret.
+var OwnedSecretPrefix = "lcm-owned-"
+var ManagedByLabel = "app.kubernetes.io/managed-by"
+var ManagedByLcm = "lcm"
+
+const ownedSecretIndex = "ownedSecret"
+
+// The webhook creates owned secrets before their pod exists,
+// so secrets no pod claims are only swept once older than the grace period
+var ownedSecretGracePeriod = 10 * time.Minute
+var ownedSecretSweepInterval = 10 * time.Minute
+
+func GetSecret(namespace string, name string) (*corev1.Secret, error) {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func DeleteSecret(namespace string, name string) error {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.CoreV1().Secrets(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if errorv1.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// OwnedSecretLabels are set on the secrets lcm creates for pods.
+func OwnedSecretLabels() map[string]string {
+	return map[string]string{ManagedByLabel: ManagedByLcm}
+}
+
+// IsOwnedSecret reports whether lcm created the secret for a pod.
+func IsOwnedSecret(secret *corev1.Secret) bool {
+	return strings.HasPrefix(secret.Name, OwnedSecretPrefix) && secret.Labels[ManagedByLabel] == ManagedByLcm
+}
+
+// PodForOwnedSecret finds the pod the named secret was created for.
+// A secret claimed by several pods belongs to none of them.
+func PodForOwnedSecret(name string) (*corev1.Pod, error) {
+	pods, err := podsClaimingSecret(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, errorv1.NewNotFound(corev1.Resource("Pod"), name)
+	}
+	if len(pods) > 1 {
+		return nil, fmt.Errorf("secret %s is claimed by %d pods", name, len(pods))
+	}
+	return pods[0], nil
+}
+
+// podsClaimingSecret lists the pods, in any namespace, naming the secret in their owned secret annotation.
+func podsClaimingSecret(name string) ([]*corev1.Pod, error) {
+	pods := []*corev1.Pod{}
+	if factory := informerFactory(); factory != nil {
+		objs, err := factory.Core().V1().Pods().Informer().GetIndexer().ByIndex(ownedSecretIndex, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			pods = append(pods, obj.(*corev1.Pod))
+		}
+		return pods, nil
+	}
+
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := client.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range podList.Items {
+		if podList.Items[i].Annotations[OwnedSecretAnnotation] == name {
+			pods = append(pods, &podList.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+func indexPodByOwnedSecret(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	if name, ok := pod.Annotations[OwnedSecretAnnotation]; ok {
+		return []string{name}, nil
+	}
+	return nil, nil
+}
+
+func deleteOwnedSecret() func(obj any) {
+	return func(obj any) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		name, ok := pod.Annotations[OwnedSecretAnnotation]
+		if !ok {
+			return
+		}
+
+		secret, err := GetSecret(pod.Namespace, name)
+		if errorv1.IsNotFound(err) {
+			return
+		} else if err != nil {
+			slog.Error("failed to get secret of pod", "namespace", pod.Namespace, "pod", pod.Name, "secret", name, "err", err)
+			return
+		}
+		if !IsOwnedSecret(secret) {
+			slog.Warn("not deleting secret lcm did not create", "namespace", pod.Namespace, "pod", pod.Name, "secret", name)
+			return
+		}
+		claimants, err := podsClaimingSecret(name)
+		if err != nil {
+			slog.Error("failed to find pods claiming secret", "namespace", pod.Namespace, "secret", name, "err", err)
+			return
+		}
+		if lo.ContainsBy(claimants, func(claimant *corev1.Pod) bool {
+			return claimant.Namespace == pod.Namespace && claimant.UID != pod.UID
+		}) {
+			slog.Warn("not deleting secret claimed by another pod", "namespace", pod.Namespace, "pod", pod.Name, "secret", name)
+			return
+		}
+
+		if err := DeleteSecret(pod.Namespace, name); err != nil {
+			slog.Error("failed to delete secret of pod", "namespace", pod.Namespace, "pod", pod.Name, "secret", name, "err", err)
+			return
+		}
+		slog.Debug("deleted secret of pod", "namespace", pod.Namespace, "pod", pod.Name, "secret", name)
+	}
+}
+
+// sweepOwnedSecrets periodically deletes the owned secrets no pod claims, left behind when a pod
+// was rejected after the webhook created its secret, or was deleted while lcm was down.
+func sweepOwnedSecrets(stop <-chan struct{}) {
+	ticker := time.NewTicker(ownedSecretSweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := deleteUnclaimedSecrets(); err != nil {
+			slog.Error("failed to sweep owned secrets", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func deleteUnclaimedSecrets() error {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	secrets, err := client.CoreV1().Secrets("").List(context.Background(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(OwnedSecretLabels()).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !IsOwnedSecret(secret) || time.Since(secret.CreationTimestamp.Time) < ownedSecretGracePeriod {
+			continue
+		}
+		claimants, err := podsClaimingSecret(secret.Name)
+		if err != nil {
+			return err
+		}
+		if lo.ContainsBy(claimants, func(claimant *corev1.Pod) bool {
+			return claimant.Namespace == secret.Namespace
+		}) {
+			continue
+		}
+
+		if err := DeleteSecret(secret.Namespace, secret.Name); err != nil {
+			slog.Error("failed to delete unclaimed secret", "namespace", secret.Namespace, "secret", secret.Name, "err", err)
+			continue
+		}
+		slog.Info("deleted unclaimed secret", "namespace", secret.Namespace, "secret", secret.Name)
+	}
+	return nil
+}
@@ -11,11 +11,13 @@ import (
 	kubeclient "github.com/magnm/lcm/pkg/kubernetes/client"
 	"github.com/magnm/lcm/pkg/util"
 	"golang.org/x/exp/slog"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	errorv1 "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	applyv1 "k8s.io/client-go/applyconfigurations/core/v1"
 	applymetav1 "k8s.io/client-go/applyconfigurations/meta/v1"
 )
@@ -49,7 +51,25 @@ func CallingPod(r *http.Request) (*corev1.Pod, error) {
 	return pod, nil
 }
 
+// LookupCallingPod resolves the calling pod without retrying,
+// for callers that can do without one.
+func LookupCallingPod(r *http.Request) (*corev1.Pod, error) {
+	return resolveCallingPod(util.RequestIp(r))
+}
+
 func resolveCallingPod(ip string) (*corev1.Pod, error) {
+	// Prefer the informer, which is always up to date
+	if factory := informerFactory(); factory != nil {
+		pods, err := factory.Core().V1().Pods().Informer().GetIndexer().ByIndex(podIpIndex, ip)
+		if err != nil {
+			return nil, err
+		}
+		if len(pods) == 0 {
+			return nil, errorv1.NewNotFound(corev1.Resource("Pod"), ip)
+		}
+		return pods[0].(*corev1.Pod), nil
+	}
+
 	// Check cache first before looking up in kube api
 	if pod, ok := podCache[ip]; ok {
 		return pod, nil
@@ -76,10 +96,32 @@ func resolveCallingPod(ip string) (*corev1.Pod, error) {
 	return &pod, nil
 }
 
+// GetPod reads the pod from the kube api, bypassing any cache.
+func GetPod(namespace string, name string) (*corev1.Pod, error) {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func PodIps(pod *corev1.Pod) []string {
+	ips := []string{}
+	for _, podIp := range pod.Status.PodIPs {
+		ips = append(ips, podIp.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips
+}
+
 func ServiceAccountForPod(pod *corev1.Pod) (*corev1.ServiceAccount, error) {
-	name := pod.Spec.ServiceAccountName
-	if name == "" {
-		name = "default"
+	name := serviceAccountName(pod)
+
+	if factory := informerFactory(); factory != nil {
+		return factory.Core().V1().ServiceAccounts().Lister().ServiceAccounts(pod.Namespace).Get(name)
 	}
 
 	client, err := kubeclient.GetKubernetesClient()
@@ -90,6 +132,55 @@ func ServiceAccountForPod(pod *corev1.Pod) (*corev1.ServiceAccount, error) {
 	return client.CoreV1().ServiceAccounts(pod.Namespace).Get(context.Background(), name, metav1.GetOptions{})
 }
 
+func serviceAccountName(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return pod.Spec.ServiceAccountName
+}
+
+// CreateServiceAccountToken issues a token for the ksa of the pod, bound to the pod, for federation with a cloud.
+func CreateServiceAccountToken(pod *corev1.Pod, audience string, lifetime time.Duration) (*authenticationv1.TokenRequestStatus, error) {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	name := serviceAccountName(pod)
+	expirationSeconds := int64(lifetime.Seconds())
+
+	tokenRequest, err := client.CoreV1().ServiceAccounts(pod.Namespace).CreateToken(context.Background(), name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{audience},
+			ExpirationSeconds: &expirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				Kind:       "Pod",
+				APIVersion: "v1",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &tokenRequest.Status, nil
+}
+
+// GetLcmConfigMap returns a ConfigMap from the namespace lcm runs in.
+func GetLcmConfigMap(name string) (*corev1.ConfigMap, error) {
+	if factory := lcmInformerFactory(); factory != nil {
+		return factory.Core().V1().ConfigMaps().Lister().ConfigMaps(config.Current.LcmNamespace).Get(name)
+	}
+
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CoreV1().ConfigMaps(config.Current.LcmNamespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
 func FindCustomResource[T any](group string, version string, resource string, namespace string) ([]T, error) {
 	client, err := kubeclient.GetKubernetesDynamicClient()
 	if err != nil {
@@ -132,6 +223,7 @@ func CreateSecret(secret *corev1.Secret) error {
 		ObjectMetaApplyConfiguration: &applymetav1.ObjectMetaApplyConfiguration{
 			Name:      &secret.Name,
 			Namespace: &secret.Namespace,
+			Labels:    secret.Labels,
 		},
 		Type:       &secret.Type,
 		Data:       secret.Data,
@@ -185,3 +277,47 @@ func GetOurServiceIp() string {
 	ourServiceIp = service.Spec.ClusterIP
 	return ourServiceIp
 }
+
+// PatchPodAnnotations sets the given annotations on the pod, removing those set to nil.
+func PatchPodAnnotations(namespace string, name string, annotations map[string]*string) error {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	patch, err := annotationsMergePatch(annotations)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CoreV1().Pods(namespace).Patch(context.Background(), name, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: config.Current.Name,
+	})
+	return err
+}
+
+// PatchNodeAnnotations sets the given annotations on the node, removing those set to nil.
+func PatchNodeAnnotations(name string, annotations map[string]*string) error {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	patch, err := annotationsMergePatch(annotations)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CoreV1().Nodes().Patch(context.Background(), name, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: config.Current.Name,
+	})
+	return err
+}
+
+func annotationsMergePatch(annotations map[string]*string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+		},
+	})
+}
@@ -0,0 +1,197 @@
+package kubernetes
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	kubeclient "github.com/magnm/lcm/pkg/kubernetes/client"
+	"github.com/magnm/lcm/pkg/util"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Changes is notified with the ChangeTopic of a watched kubernetes resource whenever it changes.
+var Changes = util.NewTopics()
+
+type syncedInformers struct {
+	cluster informers.SharedInformerFactory
+	// lcm watches resources in the namespace lcm runs in
+	lcm informers.SharedInformerFactory
+}
+
+// Published once the informers have synced, read by request goroutines
+var published atomic.Pointer[syncedInformers]
+
+var podDeletedHandlers []func(pod *corev1.Pod)
+var podDeletedHandlersLock sync.RWMutex
+
+const podIpIndex = "podIP"
+
+// StartInformers starts watching the resources metadata values are derived from.
+// Until it has synced, lookups fall back to querying the kube api directly.
+func StartInformers(stop <-chan struct{}) error {
+	client, err := kubeclient.GetKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 10*time.Minute)
+
+	podInformer := factory.Core().V1().Pods().Informer()
+	if err := podInformer.AddIndexers(cache.Indexers{
+		podIpIndex:       indexPodByIp,
+		ownedSecretIndex: indexPodByOwnedSecret,
+	}); err != nil {
+		return err
+	}
+	if _, err := podInformer.AddEventHandler(notifyOnChange("Pod")); err != nil {
+		return err
+	}
+	if _, err := podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: podDeleted,
+	}); err != nil {
+		return err
+	}
+	if _, err := podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: deleteOwnedSecret(),
+	}); err != nil {
+		return err
+	}
+
+	serviceAccountInformer := factory.Core().V1().ServiceAccounts().Informer()
+	if _, err := serviceAccountInformer.AddEventHandler(notifyOnChange("ServiceAccount")); err != nil {
+		return err
+	}
+
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+	if _, err := nodeInformer.AddEventHandler(notifyOnChange("Node")); err != nil {
+		return err
+	}
+
+	lcmFactory := informers.NewSharedInformerFactoryWithOptions(client, 10*time.Minute,
+		informers.WithNamespace(config.Current.LcmNamespace),
+	)
+
+	configMapInformer := lcmFactory.Core().V1().ConfigMaps().Informer()
+	if _, err := configMapInformer.AddEventHandler(notifyOnChange("ConfigMap")); err != nil {
+		return err
+	}
+
+	for _, f := range []informers.SharedInformerFactory{factory, lcmFactory} {
+		f.Start(stop)
+		for informerType, synced := range f.WaitForCacheSync(stop) {
+			if !synced {
+				slog.Error("informer failed to sync", "type", informerType)
+				return errors.New("informers failed to sync")
+			}
+		}
+	}
+
+	published.Store(&syncedInformers{cluster: factory, lcm: lcmFactory})
+	slog.Info("kubernetes informers synced")
+
+	// Claims are looked up in the informer, so secrets are only swept once it has synced
+	go sweepOwnedSecrets(stop)
+	return nil
+}
+
+func indexPodByIp(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return nil, nil
+	}
+
+	return PodIps(pod), nil
+}
+
+// informerFactory returns the synced cluster wide informers, or nil until they have synced.
+func informerFactory() informers.SharedInformerFactory {
+	if synced := published.Load(); synced != nil {
+		return synced.cluster
+	}
+	return nil
+}
+
+// lcmInformerFactory returns the synced informers of the lcm namespace, or nil until they have synced.
+func lcmInformerFactory() informers.SharedInformerFactory {
+	if synced := published.Load(); synced != nil {
+		return synced.lcm
+	}
+	return nil
+}
+
+// ChangeTopic names the topic Changes is notified on when the resource changes.
+// The namespace is empty for cluster scoped resources.
+func ChangeTopic(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// PodChangeTopics are the topics of the resources the metadata of a pod derives from,
+// the pod itself, its KSA and the node running it.
+func PodChangeTopics(pod *corev1.Pod) []string {
+	topics := []string{
+		ChangeTopic("Pod", pod.Namespace, pod.Name),
+		ChangeTopic("ServiceAccount", pod.Namespace, serviceAccountName(pod)),
+	}
+	if pod.Spec.NodeName != "" {
+		topics = append(topics, ChangeTopic("Node", "", pod.Spec.NodeName))
+	}
+	return topics
+}
+
+// OnPodDeleted calls handler with every pod deleted from the cluster, e.g. to forget what was cached for it.
+func OnPodDeleted(handler func(pod *corev1.Pod)) {
+	podDeletedHandlersLock.Lock()
+	defer podDeletedHandlersLock.Unlock()
+	podDeletedHandlers = append(podDeletedHandlers, handler)
+}
+
+func podDeleted(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	podDeletedHandlersLock.RLock()
+	defer podDeletedHandlersLock.RUnlock()
+	for _, handler := range podDeletedHandlers {
+		handler(pod)
+	}
+}
+
+func notifyOnChange(kind string) cache.ResourceEventHandler {
+	notify := func(obj any) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if meta, ok := obj.(metav1.Object); ok {
+			Changes.Notify(ChangeTopic(kind, meta.GetNamespace(), meta.GetName()))
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: notify,
+		UpdateFunc: func(oldObj, newObj any) {
+			// Periodic resyncs deliver updates without any actual change
+			oldMeta, oldOk := oldObj.(metav1.Object)
+			newMeta, newOk := newObj.(metav1.Object)
+			if oldOk && newOk && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				return
+			}
+			notify(newObj)
+		},
+		DeleteFunc: notify,
+	}
+}
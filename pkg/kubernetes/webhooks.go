@@ -50,10 +50,28 @@ func DecodePodMutationRequest(r *http.Request) (*admissionv1.AdmissionReview, *c
 		slog.Error("failed to decode pod resource", "err", err)
 		return nil, nil, err
 	}
+	// Pods being created don't necessarily carry their namespace yet
+	if pod.Namespace == "" {
+		pod.Namespace = admissionReview.Request.Namespace
+	}
 
 	return admissionReview, &pod, nil
 }
 
+// DecodeOldPod decodes the pod as it was before an update, or returns nil for other operations.
+func DecodeOldPod(review *admissionv1.AdmissionReview) (*corev1.Pod, error) {
+	if review.Request.Operation != admissionv1.Update || len(review.Request.OldObject.Raw) == 0 {
+		return nil, nil
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(review.Request.OldObject.Raw, &pod); err != nil {
+		slog.Error("failed to decode old pod resource", "err", err)
+		return nil, err
+	}
+	return &pod, nil
+}
+
 func EncodeMutationPatches(review *admissionv1.AdmissionReview, patches []PatchOperation) (*admissionv1.AdmissionReview, error) {
 	patchBytes, err := json.Marshal(patches)
 	if err != nil {
@@ -0,0 +1,114 @@
+package aws
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubeaws "github.com/magnm/lcm/pkg/kubernetes/aws"
+	"github.com/magnm/lcm/pkg/providers"
+	routesaws "github.com/magnm/lcm/pkg/routes/aws"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	providers.Register(&provider{})
+}
+
+// provider emulates the EC2 instance metadata service.
+type provider struct{}
+
+func (p *provider) Type() config.MetadataType {
+	return config.AwsMetadata
+}
+
+func (p *provider) Routes() http.Handler {
+	return routesaws.Routes()
+}
+
+// Matches requests with IMDSv2 token headers, or for the paths of the metadata and credentials services.
+func (p *provider) Matches(r *http.Request) bool {
+	return r.Header.Get("X-aws-ec2-metadata-token") != "" ||
+		r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") != "" ||
+		strings.HasPrefix(r.URL.Path, "/latest") ||
+		r.URL.Path == routesaws.ContainerCredentialsPath
+}
+
+// MutatePod points the sdks to the metadata service. Pods with a role also get the container credentials
+// endpoint, with an authorization token of their own, which works on the host network as well.
+func (p *provider) MutatePod(pod *corev1.Pod, dryRun bool) (*providers.PodMutation, error) {
+	mutation := &providers.PodMutation{
+		EnvVars: []corev1.EnvVar{
+			{Name: "AWS_EC2_METADATA_SERVICE_ENDPOINT", Value: "http://" + kubernetes.GetOurServiceIp()},
+		},
+	}
+
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		slog.Debug("no service account found for pod, skipping container credentials", "pod", pod.Name, "err", err)
+		return mutation, nil
+	}
+	if _, ok := ksa.Annotations[kubeaws.RoleArnAnnotation]; !ok {
+		return mutation, nil
+	}
+	uri := containerCredentialsUri()
+	if uri == "" {
+		slog.Debug("no container credentials address configured, skipping container credentials", "pod", pod.Name)
+		return mutation, nil
+	}
+	// Pods already set up, e.g. on reinvocation of the webhook, keep their token
+	if _, ok := pod.Annotations[kubernetes.OwnedSecretAnnotation]; ok {
+		return mutation, nil
+	}
+
+	secretName, err := kubeaws.CreateContainerCredentialsSecret(pod.Namespace, dryRun)
+	if err != nil {
+		slog.Error("failed to create container credentials secret", "namespace", pod.Namespace, "err", err)
+		return nil, err
+	}
+
+	mutation.EnvVars = append(mutation.EnvVars,
+		corev1.EnvVar{Name: "AWS_CONTAINER_CREDENTIALS_FULL_URI", Value: uri},
+		corev1.EnvVar{Name: "AWS_CONTAINER_AUTHORIZATION_TOKEN", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  kubeaws.ContainerCredentialsTokenKey,
+			},
+		}},
+	)
+	mutation.Annotations = map[string]string{
+		kubernetes.OwnedSecretAnnotation: secretName,
+	}
+	return mutation, nil
+}
+
+// containerCredentialsUri is where pods reach the container credentials endpoint, or empty when it is not served
+// anywhere the sdks accept. They refuse plain http to the service ip of lcm.
+func containerCredentialsUri() string {
+	if config.Current.Aws.ContainerCredentialsUri != "" {
+		return config.Current.Aws.ContainerCredentialsUri
+	}
+	if config.Current.Aws.ContainerCredentialsAddress != "" {
+		return "http://" + config.Current.Aws.ContainerCredentialsAddress + routesaws.ContainerCredentialsPath
+	}
+	return ""
+}
+
+func (p *provider) PullSecretForImage(image reference.Named, namespace string, dryRun bool) (*corev1.LocalObjectReference, error) {
+	return nil, nil
+}
+
+// IdentitiesForPod returns the role bound to the ksa of the pod.
+func (p *provider) IdentitiesForPod(pod *corev1.Pod) ([]string, error) {
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	if arn := kubeaws.GetRoleArnForKsa(ksa); arn != "" {
+		return []string{arn}, nil
+	}
+	return []string{}, nil
+}
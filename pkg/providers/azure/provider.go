@@ -0,0 +1,60 @@
+package azure
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubeazure "github.com/magnm/lcm/pkg/kubernetes/azure"
+	"github.com/magnm/lcm/pkg/providers"
+	routesazure "github.com/magnm/lcm/pkg/routes/azure"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	providers.Register(&provider{})
+}
+
+// provider emulates the Azure instance metadata service, with managed identities through azure workload identity annotations.
+type provider struct{}
+
+func (p *provider) Type() config.MetadataType {
+	return config.AzureMetadata
+}
+
+func (p *provider) Routes() http.Handler {
+	return routesazure.Routes()
+}
+
+// Matches requests with the Metadata header, or for the paths of the metadata service.
+func (p *provider) Matches(r *http.Request) bool {
+	return r.Header.Get("Metadata") != "" ||
+		strings.HasPrefix(r.URL.Path, "/metadata/")
+}
+
+// MutatePod points the managed identity credential of the sdks to lcm.
+func (p *provider) MutatePod(pod *corev1.Pod, dryRun bool) (*providers.PodMutation, error) {
+	return &providers.PodMutation{
+		EnvVars: []corev1.EnvVar{
+			{Name: "AZURE_POD_IDENTITY_AUTHORITY_HOST", Value: "http://" + kubernetes.GetOurServiceIp()},
+		},
+	}, nil
+}
+
+func (p *provider) PullSecretForImage(image reference.Named, namespace string, dryRun bool) (*corev1.LocalObjectReference, error) {
+	return nil, nil
+}
+
+// IdentitiesForPod returns the client id of the identity bound to the ksa of the pod.
+func (p *provider) IdentitiesForPod(pod *corev1.Pod) ([]string, error) {
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	if clientId := kubeazure.GetClientIdForKsa(ksa); clientId != "" {
+		return []string{clientId}, nil
+	}
+	return []string{}, nil
+}
@@ -0,0 +1,68 @@
+package google
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubegoogle "github.com/magnm/lcm/pkg/kubernetes/google"
+	"github.com/magnm/lcm/pkg/providers"
+	routesgoogle "github.com/magnm/lcm/pkg/routes/google"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	providers.Register(&provider{})
+}
+
+// provider emulates the GCE metadata server, with workload identity through iam.gke.io annotations.
+type provider struct{}
+
+func (p *provider) Type() config.MetadataType {
+	return config.GoogleMetadata
+}
+
+func (p *provider) Routes() http.Handler {
+	return routesgoogle.Routes()
+}
+
+// Matches requests with a metadata flavor header, or for the paths of the metadata server.
+func (p *provider) Matches(r *http.Request) bool {
+	return r.Header.Get("Metadata-Flavor") != "" ||
+		r.Header.Get("X-Google-Metadata-Request") != "" ||
+		strings.HasPrefix(r.URL.Path, "/computeMetadata") ||
+		strings.HasPrefix(r.URL.Path, "/0.1/")
+}
+
+func (p *provider) MutatePod(pod *corev1.Pod, dryRun bool) (*providers.PodMutation, error) {
+	return &providers.PodMutation{
+		HostAliases: []corev1.HostAlias{
+			{IP: kubernetes.GetOurServiceIp(), Hostnames: []string{kubegoogle.MetadataServerDomain}},
+		},
+		EnvVars: []corev1.EnvVar{
+			{Name: "GCE_METADATA_IP", Value: kubernetes.GetOurServiceIp()},
+			{Name: "GCE_METADATA_HOST", Value: kubegoogle.MetadataServerDomain},
+		},
+	}, nil
+}
+
+func (p *provider) PullSecretForImage(image reference.Named, namespace string, dryRun bool) (*corev1.LocalObjectReference, error) {
+	if !kubegoogle.ShouldAddImagePullSecret(image) {
+		return nil, nil
+	}
+	return kubegoogle.PullSecretForImage(image, namespace, dryRun)
+}
+
+func (p *provider) IdentitiesForPod(pod *corev1.Pod) ([]string, error) {
+	if kubegoogle.UsesDefaultAccount(pod) {
+		return []string{config.Current.DefaultAccount}, nil
+	}
+
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	return kubegoogle.ServiceAccountsForKsa(ksa), nil
+}
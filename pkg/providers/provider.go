@@ -0,0 +1,121 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/magnm/lcm/config"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Provider is a cloud whose metadata server lcm emulates.
+type Provider interface {
+	// Type is the value in TYPE or TYPES selecting this provider
+	Type() config.MetadataType
+
+	// Routes serves the metadata api of the cloud
+	Routes() http.Handler
+
+	// Matches reports whether the request is meant for this provider, by the headers or path prefixes of its api,
+	// when several providers are enabled
+	Matches(r *http.Request) bool
+
+	// MutatePod returns what the webhook adds to a pod, so its clients find the metadata server.
+	// Nothing may be created in the cluster on dry runs.
+	MutatePod(pod *corev1.Pod, dryRun bool) (*PodMutation, error)
+
+	// PullSecretForImage returns a pull secret for images hosted in the registries of the cloud,
+	// or nil for images hosted elsewhere
+	PullSecretForImage(image reference.Named, namespace string, dryRun bool) (*corev1.LocalObjectReference, error)
+
+	// IdentitiesForPod returns the cloud identities the pod may assume, the primary one first
+	IdentitiesForPod(pod *corev1.Pod) ([]string, error)
+}
+
+// PodMutation is added to pods by the webhook, leaving existing values of the pod untouched.
+type PodMutation struct {
+	HostAliases []corev1.HostAlias
+	// EnvVars are added to every container
+	EnvVars     []corev1.EnvVar
+	Annotations map[string]string
+}
+
+var registryLock sync.RWMutex
+var registry = map[config.MetadataType]Provider{}
+
+// Register makes a provider available to be selected through config.Current.EnabledTypes.
+// Providers register themselves when their package is imported.
+func Register(provider Provider) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[provider.Type()]; ok {
+		panic(fmt.Sprintf("provider %s is already registered", provider.Type()))
+	}
+	registry[provider.Type()] = provider
+}
+
+func Get(metadataType config.MetadataType) (Provider, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	provider, ok := registry[metadataType]
+	if !ok {
+		return nil, fmt.Errorf("unknown metadata type %s", metadataType)
+	}
+	return provider, nil
+}
+
+// Enabled returns the providers selected by the config, in order.
+func Enabled() ([]Provider, error) {
+	enabled := []Provider{}
+	for _, metadataType := range config.Current.EnabledTypes() {
+		provider, err := Get(metadataType)
+		if err != nil {
+			return nil, err
+		}
+		enabled = append(enabled, provider)
+	}
+	return enabled, nil
+}
+
+// Router serves each request with the first of the providers matching it, or else the first provider.
+func Router(enabled []Provider) http.Handler {
+	if len(enabled) == 1 {
+		return enabled[0].Routes()
+	}
+
+	routes := lo.Map(enabled, func(provider Provider, i int) http.Handler {
+		return provider.Routes()
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, provider := range enabled {
+			if provider.Matches(r) {
+				routes[i].ServeHTTP(w, r)
+				return
+			}
+		}
+		routes[0].ServeHTTP(w, r)
+	})
+}
+
+// IdentitiesForPod resolves the identities of the pod through the provider of the type.
+// The routes of a cloud use it, as they cannot import their provider.
+func IdentitiesForPod(metadataType config.MetadataType, pod *corev1.Pod) ([]string, error) {
+	provider, err := Get(metadataType)
+	if err != nil {
+		return nil, err
+	}
+	return provider.IdentitiesForPod(pod)
+}
+
+// Types lists the registered providers.
+func Types() []config.MetadataType {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	types := lo.Keys(registry)
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
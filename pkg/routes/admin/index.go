@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/magnm/lcm/pkg/faults"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubegoogle "github.com/magnm/lcm/pkg/kubernetes/google"
+	"github.com/samber/lo"
+	"golang.org/x/exp/slog"
+)
+
+// hostEvents maps the admin paths to the annotation holding the event, and its allowed values
+var hostEvents = map[string]struct {
+	annotation string
+	values     []string
+}{
+	"maintenance-event": {kubegoogle.MaintenanceEventAnnotation, kubegoogle.MaintenanceEvents},
+	"preempted":         {kubegoogle.PreemptedAnnotation, kubegoogle.PreemptedValues},
+}
+
+// Routes lets operators trigger simulated events for a pod or node.
+// The value is given as the request body, e.g.
+// PUT /admin/pods/default/demo/maintenance-event TERMINATE_ON_HOST_MAINTENANCE
+// A DELETE clears the event again.
+// Faults to inject for a pod are set the same way, with a json body, see faults.Faults.
+// Requests must carry the admin token as a bearer token.
+func Routes(token string) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(requireToken(token))
+	r.Put("/pods/{namespace}/{name}/faults", setPodFaults)
+	r.Delete("/pods/{namespace}/{name}/faults", setPodFaults)
+	r.Put("/pods/{namespace}/{name}/{event}", setPodEvent)
+	r.Delete("/pods/{namespace}/{name}/{event}", setPodEvent)
+	r.Put("/nodes/{name}/{event}", setNodeEvent)
+	r.Delete("/nodes/{name}/{event}", setNodeEvent)
+	return r
+}
+
+func requireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				slog.Info("rejected admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setPodEvent(w http.ResponseWriter, r *http.Request) {
+	annotations, ok := eventAnnotations(w, r)
+	if !ok {
+		return
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+	name := chi.URLParam(r, "name")
+	if err := kubernetes.PatchPodAnnotations(namespace, name, annotations); err != nil {
+		slog.Error("failed to set pod event", "namespace", namespace, "pod", name, "err", err)
+		http.Error(w, "failed to set pod event", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set pod event", "namespace", namespace, "pod", name, "annotations", annotations)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setPodFaults(w http.ResponseWriter, r *http.Request) {
+	annotations := map[string]*string{faults.Annotation: nil}
+	if r.Method == http.MethodPut {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		podFaults, err := faults.Parse(body)
+		if err != nil {
+			http.Error(w, "invalid faults: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		value, err := json.Marshal(podFaults)
+		if err != nil {
+			http.Error(w, "invalid faults: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		annotations[faults.Annotation] = lo.ToPtr(string(value))
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+	name := chi.URLParam(r, "name")
+	if err := kubernetes.PatchPodAnnotations(namespace, name, annotations); err != nil {
+		slog.Error("failed to set pod faults", "namespace", namespace, "pod", name, "err", err)
+		http.Error(w, "failed to set pod faults", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set pod faults", "namespace", namespace, "pod", name, "annotations", annotations)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setNodeEvent(w http.ResponseWriter, r *http.Request) {
+	annotations, ok := eventAnnotations(w, r)
+	if !ok {
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if err := kubernetes.PatchNodeAnnotations(name, annotations); err != nil {
+		slog.Error("failed to set node event", "node", name, "err", err)
+		http.Error(w, "failed to set node event", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set node event", "node", name, "annotations", annotations)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func eventAnnotations(w http.ResponseWriter, r *http.Request) (map[string]*string, bool) {
+	event, ok := hostEvents[chi.URLParam(r, "event")]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if r.Method == http.MethodDelete {
+		return map[string]*string{event.annotation: nil}, true
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return nil, false
+	}
+	value := strings.TrimSpace(string(body))
+	if !lo.Contains(event.values, value) {
+		http.Error(w, "value must be one of "+strings.Join(event.values, ", "), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return map[string]*string{event.annotation: &value}, true
+}
@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubeaws "github.com/magnm/lcm/pkg/kubernetes/aws"
+	"github.com/magnm/lcm/pkg/providers"
+	"golang.org/x/exp/slog"
+)
+
+// ContainerCredentialsPath serves credentials following the ECS task credentials protocol
+var ContainerCredentialsPath = "/v1/container-credentials"
+
+type containerCredentialsResponse struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+	RoleArn         string `json:"RoleArn"`
+}
+
+type containerCredentialsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// containerCredentials identifies the pod by its authorization token instead of its ip,
+// so that pods sharing the ip of the node on the host network can be told apart.
+func containerCredentials(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		writeContainerCredentialsError(w, r, http.StatusUnauthorized, "AccessDeniedException", "missing authorization token")
+		return
+	}
+
+	secretName := kubeaws.ContainerCredentialsSecretName(token)
+	pod, err := kubernetes.PodForOwnedSecret(secretName)
+	if err != nil {
+		slog.Debug("no pod found for authorization token", "secret", secretName, "err", err)
+		writeContainerCredentialsError(w, r, http.StatusForbidden, "AccessDeniedException", "invalid authorization token")
+		return
+	}
+
+	// The secret may have been deleted to revoke the token, and must be one lcm created
+	secret, err := kubernetes.GetSecret(pod.Namespace, secretName)
+	if err != nil || !kubernetes.IsOwnedSecret(secret) ||
+		subtle.ConstantTimeCompare(secret.Data[kubeaws.ContainerCredentialsTokenKey], []byte(token)) != 1 {
+		slog.Debug("authorization token does not match the secret of the pod", "pod", pod.Name, "secret", secretName, "err", err)
+		writeContainerCredentialsError(w, r, http.StatusForbidden, "AccessDeniedException", "invalid authorization token")
+		return
+	}
+
+	roles, err := providers.IdentitiesForPod(config.AwsMetadata, pod)
+	if err != nil {
+		slog.Error("failed to resolve role of pod", "err", err)
+		writeContainerCredentialsError(w, r, http.StatusInternalServerError, "InternalServerException", "failed to resolve role")
+		return
+	}
+	if len(roles) == 0 {
+		writeContainerCredentialsError(w, r, http.StatusNotFound, "ResourceNotFoundException", "no role bound to the service account")
+		return
+	}
+	arn := roles[0]
+
+	credentials, err := roleCredentials(pod, arn)
+	if err != nil {
+		writeContainerCredentialsError(w, r, http.StatusInternalServerError, "InternalServerException", "failed to assume role")
+		return
+	}
+
+	render.JSON(w, r, containerCredentialsResponse{
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		Token:           credentials.SessionToken,
+		Expiration:      credentials.ExpiresAt.UTC().Format(timeFormat),
+		RoleArn:         arn,
+	})
+}
+
+func writeContainerCredentialsError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	render.Status(r, status)
+	render.JSON(w, r, containerCredentialsError{Code: code, Message: message})
+}
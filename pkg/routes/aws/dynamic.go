@@ -0,0 +1,193 @@
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/certificates"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"golang.org/x/exp/slog"
+)
+
+// instanceIdentityDocument describes the instance, as served at /latest/dynamic/instance-identity/document
+type instanceIdentityDocument struct {
+	AccountId               string   `json:"accountId"`
+	Architecture            string   `json:"architecture"`
+	AvailabilityZone        string   `json:"availabilityZone"`
+	BillingProducts         []string `json:"billingProducts"`
+	DevpayProductCodes      []string `json:"devpayProductCodes"`
+	MarketplaceProductCodes []string `json:"marketplaceProductCodes"`
+	ImageId                 string   `json:"imageId"`
+	InstanceId              string   `json:"instanceId"`
+	InstanceType            string   `json:"instanceType"`
+	KernelId                *string  `json:"kernelId"`
+	PendingTime             string   `json:"pendingTime"`
+	PrivateIp               string   `json:"privateIp"`
+	RamdiskId               *string  `json:"ramdiskId"`
+	Region                  string   `json:"region"`
+	Version                 string   `json:"version"`
+}
+
+// Architectures of kubernetes nodes as named by EC2
+var architectures = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "arm64",
+}
+
+var documentSigner *certificates.DocumentSigner
+var documentSignerOnce sync.Once
+
+var rsa2048Signer *certificates.DocumentSigner
+var rsa2048SignerOnce sync.Once
+
+// dynamicRoot is the tree served below /latest/dynamic/
+func dynamicRoot() *tree.Node {
+	return tree.Directory(
+		tree.Child("instance-identity", tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+			entries := []tree.Entry{
+				tree.Child("document", value(identityDocumentJson)),
+			}
+			if getDocumentSigner() != nil {
+				entries = append(entries, tree.Child("pkcs7", value(identityDocumentPkcs7)))
+			}
+			if getRsa2048Signer() != nil {
+				entries = append(entries, tree.Child("rsa2048", value(identityDocumentRsa2048)))
+			}
+			if getDocumentSigner() != nil {
+				entries = append(entries, tree.Child("signature", value(identityDocumentSignature)))
+			}
+			return entries, nil
+		})),
+	)
+}
+
+func getDocumentSigner() *certificates.DocumentSigner {
+	documentSignerOnce.Do(func() {
+		cfg := config.Current.Aws
+		if cfg.IdentityDocumentCert == "" || cfg.IdentityDocumentKey == "" {
+			return
+		}
+		signer, err := certificates.LoadDocumentSigner(cfg.IdentityDocumentCert, cfg.IdentityDocumentKey)
+		if err != nil {
+			slog.Error("failed to load identity document signing key", "err", err)
+			return
+		}
+		documentSigner = signer
+	})
+	return documentSigner
+}
+
+// getRsa2048Signer loads the key of the rsa2048 signature, which like on EC2 is separate from the other signatures.
+func getRsa2048Signer() *certificates.DocumentSigner {
+	rsa2048SignerOnce.Do(func() {
+		cfg := config.Current.Aws
+		if cfg.IdentityDocumentRsa2048Cert == "" || cfg.IdentityDocumentRsa2048Key == "" {
+			return
+		}
+		signer, err := certificates.LoadDocumentSigner(cfg.IdentityDocumentRsa2048Cert, cfg.IdentityDocumentRsa2048Key)
+		if err != nil {
+			slog.Error("failed to load rsa2048 identity document signing key", "err", err)
+			return
+		}
+		if signer.KeySize() != 2048 {
+			slog.Error("rsa2048 identity document signing key must have 2048 bits", "bits", signer.KeySize())
+			return
+		}
+		rsa2048Signer = signer
+	})
+	return rsa2048Signer
+}
+
+func identityDocumentSignature(r *http.Request) (string, error) {
+	document, err := identityDocumentJson(r)
+	if err != nil {
+		return "", err
+	}
+	signature, err := getDocumentSigner().Sign([]byte(document))
+	if err != nil {
+		return "", err
+	}
+	return wrappedBase64(signature), nil
+}
+
+func identityDocumentPkcs7(r *http.Request) (string, error) {
+	return signedPkcs7(r, getDocumentSigner())
+}
+
+func identityDocumentRsa2048(r *http.Request) (string, error) {
+	return signedPkcs7(r, getRsa2048Signer())
+}
+
+// signedPkcs7 is a detached signature of the document, base64 encoded without pem headers like on EC2.
+func signedPkcs7(r *http.Request, signer *certificates.DocumentSigner) (string, error) {
+	document, err := identityDocumentJson(r)
+	if err != nil {
+		return "", err
+	}
+	signature, err := signer.SignPkcs7([]byte(document))
+	if err != nil {
+		return "", err
+	}
+	return wrappedBase64(signature), nil
+}
+
+func wrappedBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	lines := []string{}
+	for len(encoded) > 64 {
+		lines = append(lines, encoded[:64])
+		encoded = encoded[64:]
+	}
+	return strings.Join(append(lines, encoded), "\n")
+}
+
+func identityDocumentJson(r *http.Request) (string, error) {
+	document, err := identityDocument(r)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func identityDocument(r *http.Request) (*instanceIdentityDocument, error) {
+	pod, node, err := callingInstance(r)
+	if err != nil {
+		return nil, tree.NotFound()
+	}
+
+	document := &instanceIdentityDocument{
+		AccountId:        config.Current.Aws.AccountId,
+		Architecture:     architectures["amd64"],
+		AvailabilityZone: zoneName(r),
+		Region:           regionOfZone(zoneName(r)),
+		Version:          "2017-09-30",
+	}
+	if node != nil {
+		if architecture, ok := architectures[node.Status.NodeInfo.Architecture]; ok {
+			document.Architecture = architecture
+		}
+		document.PendingTime = node.CreationTimestamp.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	if ipv4 := podIpv4(pod); ipv4.IsValid() {
+		document.PrivateIp = ipv4.String()
+	}
+	if document.ImageId, err = amiId(r); err != nil {
+		return nil, err
+	}
+	if document.InstanceId, err = instanceId(r); err != nil {
+		return nil, err
+	}
+	if document.InstanceType, err = instanceType(r); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
@@ -0,0 +1,148 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	awsclient "github.com/magnm/lcm/pkg/cloud/client/aws"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubeaws "github.com/magnm/lcm/pkg/kubernetes/aws"
+	"github.com/magnm/lcm/pkg/providers"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var timeFormat = "2006-01-02T15:04:05Z"
+
+// Credentials are renewed once they expire within this margin
+var credentialsExpiryMargin = 15 * time.Minute
+
+type credentialsCacheKey struct {
+	RoleArn     string
+	SessionName string
+}
+
+var roleCredentialsCache = map[credentialsCacheKey]*awsclient.Credentials{}
+var roleCredentialsLock sync.Mutex
+
+type securityCredentialsResponse struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+type iamInfoResponse struct {
+	Code               string `json:"Code"`
+	LastUpdated        string `json:"LastUpdated"`
+	InstanceProfileArn string `json:"InstanceProfileArn"`
+	InstanceProfileId  string `json:"InstanceProfileId"`
+}
+
+// iamNode serves the role bound to the ksa of the calling pod as the instance profile.
+// Like on instances without a profile, it is not found for pods without a role.
+func iamNode() *tree.Node {
+	return tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		pod, arn := roleForPod(r)
+		if arn == "" {
+			return nil, tree.NotFound()
+		}
+
+		return []tree.Entry{
+			tree.Child("info", value(func(r *http.Request) (string, error) {
+				return iamInfo(arn)
+			})),
+			tree.Child("security-credentials", tree.Directory(
+				tree.Child(kubeaws.RoleName(arn), value(func(r *http.Request) (string, error) {
+					return securityCredentials(pod, arn)
+				})),
+			)),
+		}, nil
+	})
+}
+
+func iamInfo(arn string) (string, error) {
+	info := iamInfoResponse{
+		Code:               "Success",
+		LastUpdated:        time.Now().UTC().Format(timeFormat),
+		InstanceProfileArn: "arn:aws:iam::" + config.Current.Aws.AccountId + ":instance-profile/" + kubeaws.RoleName(arn),
+		InstanceProfileId:  fmt.Sprintf("AIPA%017X", kubernetes.NumericId(arn)),
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	return string(data), err
+}
+
+func securityCredentials(pod *corev1.Pod, arn string) (string, error) {
+	credentials, err := roleCredentials(pod, arn)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(securityCredentialsResponse{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(timeFormat),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		Token:           credentials.SessionToken,
+		Expiration:      credentials.ExpiresAt.UTC().Format(timeFormat),
+	}, "", "  ")
+	return string(data), err
+}
+
+// roleCredentials returns cached credentials of the role for the pod, assuming the role again when they expire soon.
+func roleCredentials(pod *corev1.Pod, arn string) (*awsclient.Credentials, error) {
+	key := credentialsCacheKey{RoleArn: arn, SessionName: kubeaws.SessionNameForPod(pod)}
+
+	roleCredentialsLock.Lock()
+	credentials, ok := roleCredentialsCache[key]
+	roleCredentialsLock.Unlock()
+	if ok && time.Now().Add(credentialsExpiryMargin).Before(credentials.ExpiresAt) {
+		return credentials, nil
+	}
+
+	credentials, err := awsclient.AssumeRole(key.RoleArn, key.SessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	roleCredentialsLock.Lock()
+	// Sessions are named after pods, so expired credentials are dropped to forget pods that are gone
+	now := time.Now()
+	for cachedKey, cached := range roleCredentialsCache {
+		if now.After(cached.ExpiresAt) {
+			delete(roleCredentialsCache, cachedKey)
+		}
+	}
+	roleCredentialsCache[key] = credentials
+	roleCredentialsLock.Unlock()
+
+	return credentials, nil
+}
+
+// roleForPod resolves the role bound to the ksa of the calling pod, or an empty arn if there is none.
+func roleForPod(r *http.Request) (*corev1.Pod, string) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		slog.Error("failed to get calling pod", "err", err)
+		return nil, ""
+	}
+
+	roles, err := providers.IdentitiesForPod(config.AwsMetadata, pod)
+	if err != nil {
+		slog.Error("failed to resolve role of pod", "err", err)
+		return pod, ""
+	}
+	if len(roles) == 0 {
+		return pod, ""
+	}
+	return pod, roles[0]
+}
@@ -0,0 +1,46 @@
+package aws
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"golang.org/x/exp/slog"
+)
+
+func Routes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(serverHeaders)
+	r.Put("/latest/api/token", putToken)
+	r.Get(ContainerCredentialsPath, containerCredentials)
+	r.Group(func(r chi.Router) {
+		r.Use(verifySessionToken)
+		r.Get("/", listing("latest"))
+		r.Get("/latest", listing("dynamic", "meta-data"))
+		r.Get("/latest/", listing("dynamic", "meta-data"))
+		r.Get("/latest/meta-data*", metadataHandler(metaDataRoot()))
+		r.Get("/latest/dynamic*", metadataHandler(dynamicRoot()))
+	})
+	return r
+}
+
+// ContainerCredentialsRoutes only serves the container credentials endpoint, for the node-local listener.
+func ContainerCredentialsRoutes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get(ContainerCredentialsPath, containerCredentials)
+	return r
+}
+
+func serverHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("aws metadata request", "method", r.Method, "path", r.URL.Path)
+		w.Header().Set("Server", "EC2ws")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func listing(names ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeText(w, strings.Join(names, "\n"))
+	}
+}
@@ -0,0 +1,56 @@
+package aws
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sizes of the c5, m5 and r5 families by number of cpus
+var instanceSizes = []struct {
+	cpus int64
+	name string
+}{
+	{2, "large"},
+	{4, "xlarge"},
+	{8, "2xlarge"},
+	{16, "4xlarge"},
+	{32, "8xlarge"},
+	{48, "12xlarge"},
+	{64, "16xlarge"},
+	{96, "24xlarge"},
+}
+
+// instanceTypeForNode picks the instance type closest to the capacity of the node.
+// Single cpu nodes map to t3 types, larger ones to c5, m5 or r5 by memory per cpu.
+func instanceTypeForNode(node *corev1.Node) string {
+	cpus := node.Status.Capacity.Cpu().Value()
+	memoryGiB := float64(node.Status.Capacity.Memory().Value()) / (1 << 30)
+
+	if cpus <= 1 {
+		switch {
+		case memoryGiB <= 1:
+			return "t3.micro"
+		case memoryGiB <= 2:
+			return "t3.small"
+		default:
+			return "t3.medium"
+		}
+	}
+
+	family := "m5"
+	switch perCpu := memoryGiB / float64(cpus); {
+	case perCpu < 3:
+		family = "c5"
+	case perCpu >= 6:
+		family = "r5"
+	}
+
+	size := instanceSizes[len(instanceSizes)-1].name
+	for _, s := range instanceSizes {
+		if s.cpus >= cpus {
+			size = s.name
+			break
+		}
+	}
+
+	return family + "." + size
+}
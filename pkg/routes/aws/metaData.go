@@ -0,0 +1,265 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Used when neither config nor the node tells us otherwise
+var defaultZone = "us-east-1a"
+var defaultInstanceType = "m5.large"
+
+// metaDataRoot is the tree served below /latest/meta-data/.
+// The calling pod sees the node running it as its instance, with the pod's own address.
+func metaDataRoot() *tree.Node {
+	return tree.Directory(
+		tree.Child("ami-id", value(amiId)),
+		tree.Child("ami-launch-index", tree.StaticValue("0")),
+		tree.Child("ami-manifest-path", tree.StaticValue("(unknown)")),
+		tree.Child("block-device-mapping", tree.Directory(
+			tree.Child("ami", tree.StaticValue("/dev/xvda")),
+			tree.Child("root", tree.StaticValue("/dev/xvda")),
+		)),
+		tree.Child("events", tree.Directory(
+			tree.Child("maintenance", tree.Directory(
+				tree.Child("history", tree.StaticValue("[]")),
+				tree.Child("scheduled", tree.StaticValue("[]")),
+			)),
+		)),
+		tree.Child("hostname", value(localHostname)),
+		tree.Child("iam", iamNode()),
+		tree.Child("instance-action", tree.StaticValue("none")),
+		tree.Child("instance-id", value(instanceId)),
+		tree.Child("instance-life-cycle", tree.StaticValue("on-demand")),
+		tree.Child("instance-type", value(instanceType)),
+		tree.Child("local-hostname", value(localHostname)),
+		tree.Child("local-ipv4", value(localIpv4)),
+		tree.Child("mac", value(mac)),
+		tree.Child("network", tree.Directory(
+			tree.Child("interfaces", tree.Directory(
+				tree.Child("macs", tree.DynamicDirectory(macs)),
+			)),
+		)),
+		tree.Child("placement", tree.Directory(
+			tree.Child("availability-zone", value(availabilityZone)),
+			tree.Child("region", value(region)),
+		)),
+		tree.Child("profile", tree.StaticValue("default-hvm")),
+		tree.Child("reservation-id", value(reservationId)),
+		tree.Child("security-groups", tree.StaticValue("default")),
+		tree.Child("services", tree.Directory(
+			tree.Child("domain", tree.StaticValue("amazonaws.com")),
+			tree.Child("partition", tree.StaticValue("aws")),
+		)),
+	)
+}
+
+// callingInstance returns the calling pod and the node running it, if it can be found.
+func callingInstance(r *http.Request) (*corev1.Pod, *corev1.Node, error) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	node, err := kubernetes.NodeForPod(pod)
+	if err != nil {
+		return pod, nil, nil
+	}
+	return pod, node, nil
+}
+
+// resourceId formats an id the way EC2 does, a prefix with 17 hex digits, derived from the seed.
+func resourceId(prefix string, seed string) string {
+	return fmt.Sprintf("%s-%017x", prefix, kubernetes.NumericId(seed))
+}
+
+func instanceId(r *http.Request) (string, error) {
+	if config.Current.Instance.Id != 0 {
+		return fmt.Sprintf("i-%017x", config.Current.Instance.Id), nil
+	}
+	if _, node, err := callingInstance(r); err == nil && node != nil {
+		return resourceId("i", string(node.UID)), nil
+	}
+	if node, err := kubernetes.OurNode(); err == nil {
+		return resourceId("i", string(node.UID)), nil
+	}
+	return resourceId("i", config.Current.Aws.AccountId), nil
+}
+
+func reservationId(r *http.Request) (string, error) {
+	id, err := instanceId(r)
+	if err != nil {
+		return "", err
+	}
+	return resourceId("r", id), nil
+}
+
+// amiId is the configured image, or one named after the os of the node.
+func amiId(r *http.Request) (string, error) {
+	if strings.HasPrefix(config.Current.Instance.Image, "ami-") {
+		return config.Current.Instance.Image, nil
+	}
+	osImage := "amazon-linux-2"
+	if _, node, err := callingInstance(r); err == nil && node != nil && node.Status.NodeInfo.OSImage != "" {
+		osImage = node.Status.NodeInfo.OSImage
+	}
+	return resourceId("ami", osImage), nil
+}
+
+func instanceType(r *http.Request) (string, error) {
+	if config.Current.Instance.MachineType != "" {
+		return config.Current.Instance.MachineType, nil
+	}
+	if _, node, err := callingInstance(r); err == nil && node != nil {
+		return instanceTypeForNode(node), nil
+	}
+	return defaultInstanceType, nil
+}
+
+func localIpv4(r *http.Request) (string, error) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		return "", tree.NotFound()
+	}
+	ipv4 := podIpv4(pod)
+	if !ipv4.IsValid() {
+		return "", tree.NotFound()
+	}
+	return ipv4.String(), nil
+}
+
+// localHostname follows the ip based naming of EC2, e.g. ip-10-0-0-1.eu-west-1.compute.internal
+func localHostname(r *http.Request) (string, error) {
+	ip, err := localIpv4(r)
+	if err != nil {
+		return "", err
+	}
+	return "ip-" + strings.ReplaceAll(ip, ".", "-") + "." + internalDomain(zoneName(r)), nil
+}
+
+func internalDomain(zone string) string {
+	if regionOfZone(zone) == "us-east-1" {
+		return "ec2.internal"
+	}
+	return regionOfZone(zone) + ".compute.internal"
+}
+
+func mac(r *http.Request) (string, error) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		return "", tree.NotFound()
+	}
+	return macAddress(pod), nil
+}
+
+// macs lists the single interface of the instance, keyed by its mac address.
+func macs(r *http.Request) ([]tree.Entry, error) {
+	pod, node, err := callingInstance(r)
+	if err != nil {
+		return nil, tree.NotFound()
+	}
+
+	ipv4 := podIpv4(pod)
+	entries := []tree.Entry{
+		tree.Child("device-number", tree.StaticValue("0")),
+		tree.Child("interface-id", tree.StaticValue(resourceId("eni", string(pod.UID)))),
+	}
+	if ipv4.IsValid() {
+		entries = append(entries,
+			tree.Child("local-hostname", value(localHostname)),
+			tree.Child("local-ipv4s", tree.StaticValue(ipv4.String())),
+		)
+	}
+	entries = append(entries,
+		tree.Child("mac", tree.StaticValue(macAddress(pod))),
+		tree.Child("owner-id", tree.StaticValue(config.Current.Aws.AccountId)),
+	)
+	if externalIp := kubernetes.NodeAddress(node, corev1.NodeExternalIP); externalIp != "" {
+		entries = append(entries, tree.Child("public-ipv4s", tree.StaticValue(externalIp)))
+	}
+	entries = append(entries,
+		tree.Child("security-group-ids", tree.StaticValue(resourceId("sg", config.Current.Aws.AccountId))),
+		tree.Child("security-groups", tree.StaticValue("default")),
+	)
+	if ipv4.IsValid() {
+		subnet := kubernetes.PodSubnet(node, ipv4)
+		entries = append(entries,
+			tree.Child("subnet-id", tree.StaticValue(resourceId("subnet", subnet.String()))),
+			tree.Child("subnet-ipv4-cidr-block", tree.StaticValue(subnet.String())),
+		)
+	}
+	entries = append(entries, tree.Child("vpc-id", value(vpcId)))
+
+	return []tree.Entry{
+		tree.Child(macAddress(pod), tree.Directory(entries...)),
+	}, nil
+}
+
+// vpcId stands for the cluster network, so it is shared by all pods of the cluster.
+func vpcId(r *http.Request) (string, error) {
+	if uid, err := kubernetes.ClusterUid(); err == nil {
+		return resourceId("vpc", uid), nil
+	}
+	return resourceId("vpc", config.Current.Aws.AccountId), nil
+}
+
+func podIpv4(pod *corev1.Pod) netip.Addr {
+	for _, podIp := range kubernetes.PodIps(pod) {
+		if addr, err := netip.ParseAddr(podIp); err == nil && addr.Is4() {
+			return addr
+		}
+	}
+	return netip.Addr{}
+}
+
+// macAddress is a locally administered address embedding the ip of the pod, or else derived from its uid.
+func macAddress(pod *corev1.Pod) string {
+	var suffix [4]byte
+	if ipv4 := podIpv4(pod); ipv4.IsValid() {
+		suffix = ipv4.As4()
+	} else {
+		id := kubernetes.NumericId(string(pod.UID))
+		suffix = [4]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	}
+	return fmt.Sprintf("02:00:%02x:%02x:%02x:%02x", suffix[0], suffix[1], suffix[2], suffix[3])
+}
+
+func availabilityZone(r *http.Request) (string, error) {
+	return zoneName(r), nil
+}
+
+func region(r *http.Request) (string, error) {
+	return regionOfZone(zoneName(r)), nil
+}
+
+// zoneName resolves the zone from config, or the topology labels of the node running the calling pod,
+// or of the node lcm runs on. Nodes labelled with only a region report the region.
+func zoneName(r *http.Request) string {
+	if config.Current.Instance.Zone != "" {
+		return config.Current.Instance.Zone
+	}
+	nodes := []*corev1.Node{}
+	if _, node, err := callingInstance(r); err == nil && node != nil {
+		nodes = append(nodes, node)
+	}
+	if node, err := kubernetes.OurNode(); err == nil {
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		if zone := kubernetes.ZoneOfNode(node); zone != "" {
+			return zone
+		}
+	}
+	return defaultZone
+}
+
+// regionOfZone strips the zone letter, e.g. eu-west-1b becomes eu-west-1
+func regionOfZone(zone string) string {
+	return strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+}
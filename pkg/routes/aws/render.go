@@ -0,0 +1,61 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"golang.org/x/exp/slog"
+)
+
+// value wraps a resolver of a text value, the only kind of value EC2 serves.
+func value(resolve func(r *http.Request) (string, error)) *tree.Node {
+	return tree.Value(func(r *http.Request) (any, error) {
+		return resolve(r)
+	})
+}
+
+// metadataHandler serves the tree below the wildcard of the route. Directories are listed one entry per line,
+// with a trailing slash on subdirectories, the same with or without a trailing slash in the request.
+func metadataHandler(root *tree.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		node, err := root.Lookup(r, tree.Segments(chi.URLParam(r, "*")))
+		if err != nil {
+			writeMetadataError(w, r, err)
+			return
+		}
+
+		if !node.IsDirectory() {
+			val, err := node.Value(r)
+			if err != nil {
+				writeMetadataError(w, r, err)
+				return
+			}
+			writeText(w, fmt.Sprint(val))
+			return
+		}
+
+		names, err := node.Listing(r)
+		if err != nil {
+			writeMetadataError(w, r, err)
+			return
+		}
+		writeText(w, strings.Join(names, "\n"))
+	}
+}
+
+func writeMetadataError(w http.ResponseWriter, r *http.Request, err error) {
+	if tree.IsNotFound(err) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	slog.Error("failed to serve metadata", "path", r.URL.Path, "err", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+func writeText(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(text)) //nolint:errcheck
+}
@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/magnm/lcm/pkg/util"
+	"golang.org/x/exp/slog"
+)
+
+var tokenHeader = "X-aws-ec2-metadata-token"
+var tokenTtlHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+var maxTokenTtl = 21600 * time.Second
+
+// sessionToken is bound to the ip that requested it, like a token can't leave the instance on EC2
+type sessionToken struct {
+	ip        string
+	expiresAt time.Time
+}
+
+var sessionTokensLock sync.Mutex
+var sessionTokens = map[string]sessionToken{}
+
+// putToken starts an IMDSv2 session, returning a token valid for the requested number of seconds.
+func putToken(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	seconds, err := strconv.Atoi(r.Header.Get(tokenTtlHeader))
+	if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxTokenTtl {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// On EC2 the ip ttl of the response is the hop limit, and responses to callers further away are dropped
+	if hops := requestHops(r); hops > config.Current.Aws.HopLimit {
+		slog.Debug("dropping token response beyond the hop limit", "hops", hops, "limit", config.Current.Aws.HopLimit)
+		panic(http.ErrAbortHandler)
+	}
+
+	token, err := newSessionToken(util.RequestIp(r), time.Duration(seconds)*time.Second)
+	if err != nil {
+		slog.Error("failed to create session token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(tokenTtlHeader, strconv.Itoa(seconds))
+	writeText(w, token)
+}
+
+// requestHops is the number of hops the token response would travel:
+// one for pods on the host network, like processes on the instance, and two for other pods, like containers.
+func requestHops(r *http.Request) int {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil || pod.Spec.HostNetwork {
+		return 1
+	}
+	return 2
+}
+
+func newSessionToken(ip string, ttl time.Duration) (string, error) {
+	data := make([]byte, 42)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(data)
+
+	sessionTokensLock.Lock()
+	defer sessionTokensLock.Unlock()
+
+	now := time.Now()
+	for key, existing := range sessionTokens {
+		if now.After(existing.expiresAt) {
+			delete(sessionTokens, key)
+		}
+	}
+	sessionTokens[token] = sessionToken{ip: ip, expiresAt: now.Add(ttl)}
+
+	return token, nil
+}
+
+// verifySessionToken requires a valid session token when one is given, or when IMDSv1 is disabled.
+// The remaining lifetime of the token is returned in the ttl header.
+func verifySessionToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(tokenHeader)
+		if token == "" {
+			if config.Current.Aws.HttpTokens == "required" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionTokensLock.Lock()
+		session, ok := sessionTokens[token]
+		sessionTokensLock.Unlock()
+
+		remaining := time.Until(session.expiresAt)
+		if !ok || remaining <= 0 || session.ip != util.RequestIp(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set(tokenTtlHeader, strconv.Itoa(int(remaining.Seconds())))
+		next.ServeHTTP(w, r)
+	})
+}
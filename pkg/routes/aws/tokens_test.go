@@ -0,0 +1,133 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/magnm/lcm/config"
+)
+
+func TestPutToken(t *testing.T) {
+	config.Current.Aws.HopLimit = 2
+
+	tests := []struct {
+		name      string
+		ttl       string
+		forwarded bool
+		status    int
+	}{
+		{name: "shortest ttl", ttl: "1", status: http.StatusOK},
+		{name: "longest ttl", ttl: "21600", status: http.StatusOK},
+		{name: "missing ttl", ttl: "", status: http.StatusBadRequest},
+		{name: "zero ttl", ttl: "0", status: http.StatusBadRequest},
+		{name: "ttl beyond six hours", ttl: "21601", status: http.StatusBadRequest},
+		{name: "ttl not a number", ttl: "1h", status: http.StatusBadRequest},
+		{name: "forwarded request", ttl: "60", forwarded: true, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/latest/api/token", nil)
+			r.Header.Set(tokenTtlHeader, tt.ttl)
+			if tt.forwarded {
+				r.Header.Set("X-Forwarded-For", "10.0.0.2")
+			}
+			w := httptest.NewRecorder()
+			putToken(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if w.Header().Get(tokenTtlHeader) != tt.ttl {
+				t.Errorf("ttl header = %s, want %s", w.Header().Get(tokenTtlHeader), tt.ttl)
+			}
+
+			token := w.Body.String()
+			sessionTokensLock.Lock()
+			session, ok := sessionTokens[token]
+			sessionTokensLock.Unlock()
+			if !ok {
+				t.Fatal("token was not stored")
+			}
+			seconds, _ := strconv.Atoi(tt.ttl)
+			if remaining := time.Until(session.expiresAt); remaining <= 0 || remaining > time.Duration(seconds)*time.Second {
+				t.Errorf("token expires in %s, want %ss", remaining, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestVerifySessionToken(t *testing.T) {
+	valid, err := newSessionToken("10.0.0.1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionTokensLock.Lock()
+	sessionTokens["expired"] = sessionToken{ip: "10.0.0.1", expiresAt: time.Now().Add(-time.Second)}
+	sessionTokensLock.Unlock()
+
+	tests := []struct {
+		name       string
+		httpTokens string
+		token      string
+		remoteAddr string
+		status     int
+	}{
+		{name: "valid token", httpTokens: "optional", token: valid, remoteAddr: "10.0.0.1:40000", status: http.StatusOK},
+		{name: "valid token from another port", httpTokens: "required", token: valid, remoteAddr: "10.0.0.1:50000", status: http.StatusOK},
+		{name: "token from another ip", httpTokens: "optional", token: valid, remoteAddr: "10.0.0.2:40000", status: http.StatusUnauthorized},
+		{name: "expired token", httpTokens: "optional", token: "expired", remoteAddr: "10.0.0.1:40000", status: http.StatusUnauthorized},
+		{name: "unknown token", httpTokens: "optional", token: "unknown", remoteAddr: "10.0.0.1:40000", status: http.StatusUnauthorized},
+		{name: "no token with IMDSv1", httpTokens: "optional", remoteAddr: "10.0.0.1:40000", status: http.StatusOK},
+		{name: "no token with IMDSv2 required", httpTokens: "required", remoteAddr: "10.0.0.1:40000", status: http.StatusUnauthorized},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Current.Aws.HttpTokens = tt.httpTokens
+			r := httptest.NewRequest(http.MethodGet, "/latest/meta-data/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				r.Header.Set(tokenHeader, tt.token)
+			}
+			w := httptest.NewRecorder()
+			verifySessionToken(next).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			// The remaining lifetime is returned with requests using a token
+			if tt.status == http.StatusOK && tt.token != "" {
+				remaining, err := strconv.Atoi(w.Header().Get(tokenTtlHeader))
+				if err != nil || remaining <= 0 || remaining > 60 {
+					t.Errorf("ttl header = %q, want the remaining seconds", w.Header().Get(tokenTtlHeader))
+				}
+			}
+		})
+	}
+}
+
+func TestNewSessionTokenDropsExpired(t *testing.T) {
+	sessionTokensLock.Lock()
+	sessionTokens["stale"] = sessionToken{ip: "10.0.0.1", expiresAt: time.Now().Add(-time.Minute)}
+	sessionTokensLock.Unlock()
+
+	if _, err := newSessionToken("10.0.0.1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionTokensLock.Lock()
+	defer sessionTokensLock.Unlock()
+	if _, ok := sessionTokens["stale"]; ok {
+		t.Error("expired token was kept")
+	}
+}
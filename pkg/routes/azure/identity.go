@@ -0,0 +1,152 @@
+package azure
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/magnm/lcm/config"
+	azureclient "github.com/magnm/lcm/pkg/cloud/client/azure"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubeazure "github.com/magnm/lcm/pkg/kubernetes/azure"
+	"github.com/magnm/lcm/pkg/providers"
+	"golang.org/x/exp/slog"
+)
+
+// Tokens are exchanged again once they expire within this margin
+var tokenExpiryMargin = 5 * time.Minute
+
+// Lifetime of the ksa tokens used as client assertion
+var assertionLifetime = 10 * time.Minute
+
+// tokenCacheKey includes the ksa, the subject of the federated credential,
+// so a token exchanged for one ksa is never handed to another ksa naming the same identity.
+type tokenCacheKey struct {
+	Namespace      string
+	ServiceAccount string
+	TenantId       string
+	ClientId       string
+	Resource       string
+}
+
+var identityTokenCache = map[tokenCacheKey]*azureclient.Token{}
+var identityTokenLock sync.Mutex
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ClientId     string `json:"client_id"`
+	ExpiresIn    string `json:"expires_in"`
+	ExpiresOn    string `json:"expires_on"`
+	ExtExpiresIn string `json:"ext_expires_in"`
+	NotBefore    string `json:"not_before"`
+	Resource     string `json:"resource"`
+	TokenType    string `json:"token_type"`
+}
+
+type tokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// identityToken serves an access token of the managed identity bound to the ksa of the calling pod.
+// Tokens are exchanged for a ksa token at the token endpoint, like azure workload identity does.
+func identityToken(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	resource := query.Get("resource")
+	if resource == "" {
+		writeError(w, r, http.StatusBadRequest, tokenError{
+			Error:            "invalid_request",
+			ErrorDescription: "Required query variable 'resource' is missing",
+		})
+		return
+	}
+
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		slog.Error("failed to get calling pod", "err", err)
+		writeError(w, r, http.StatusBadRequest, tokenError{Error: "invalid_request", ErrorDescription: "Identity not found"})
+		return
+	}
+	identities, err := providers.IdentitiesForPod(config.AzureMetadata, pod)
+	if err != nil {
+		slog.Error("failed to resolve identity of pod", "err", err)
+		writeError(w, r, http.StatusBadRequest, tokenError{Error: "invalid_request", ErrorDescription: "Identity not found"})
+		return
+	}
+
+	// Only the identity of the ksa can be requested, by client id or without selecting one
+	requested := query.Get("client_id")
+	if len(identities) == 0 || (requested != "" && requested != identities[0]) || query.Has("object_id") || query.Has("msi_res_id") {
+		writeError(w, r, http.StatusBadRequest, tokenError{Error: "invalid_request", ErrorDescription: "Identity not found"})
+		return
+	}
+	clientId := identities[0]
+
+	// The tenant is declared on the ksa as well, which is the subject of the federated credential
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		slog.Error("failed to get service account for pod", "err", err)
+		writeError(w, r, http.StatusBadRequest, tokenError{Error: "invalid_request", ErrorDescription: "Identity not found"})
+		return
+	}
+
+	key := tokenCacheKey{
+		Namespace:      ksa.Namespace,
+		ServiceAccount: ksa.Name,
+		TenantId:       kubeazure.GetTenantIdForKsa(ksa),
+		ClientId:       clientId,
+		Resource:       resource,
+	}
+
+	identityTokenLock.Lock()
+	token, ok := identityTokenCache[key]
+	identityTokenLock.Unlock()
+
+	if !ok || time.Now().Add(tokenExpiryMargin).After(token.ExpiresAt) {
+		assertion, err := kubernetes.CreateServiceAccountToken(pod, config.Current.Azure.TokenAudience, assertionLifetime)
+		if err != nil {
+			slog.Error("failed to create service account token", "namespace", pod.Namespace, "ksa", ksa.Name, "err", err)
+			writeError(w, r, http.StatusInternalServerError, tokenError{Error: "unknown_error", ErrorDescription: "Failed to get token"})
+			return
+		}
+
+		token, err = azureclient.ExchangeToken(key.TenantId, clientId, assertion.Token, scopeOfResource(resource))
+		if err != nil {
+			slog.Error("failed to exchange token", "clientId", clientId, "resource", resource, "err", err)
+			writeError(w, r, http.StatusInternalServerError, tokenError{Error: "unknown_error", ErrorDescription: "Failed to get token"})
+			return
+		}
+
+		identityTokenLock.Lock()
+		// Resources are chosen by the caller, so expired tokens are dropped to keep the cache bounded
+		now := time.Now()
+		for cachedKey, cached := range identityTokenCache {
+			if now.After(cached.ExpiresAt) {
+				delete(identityTokenCache, cachedKey)
+			}
+		}
+		identityTokenCache[key] = token
+		identityTokenLock.Unlock()
+	}
+
+	now := time.Now()
+	expiresIn := strconv.Itoa(int(token.ExpiresAt.Sub(now).Seconds()))
+	render.JSON(w, r, tokenResponse{
+		AccessToken:  token.AccessToken,
+		ClientId:     clientId,
+		ExpiresIn:    expiresIn,
+		ExpiresOn:    strconv.FormatInt(token.ExpiresAt.Unix(), 10),
+		ExtExpiresIn: expiresIn,
+		NotBefore:    strconv.FormatInt(now.Unix(), 10),
+		Resource:     resource,
+		TokenType:    "Bearer",
+	})
+}
+
+// scopeOfResource turns a v1 resource into a v2 scope, e.g. https://vault.azure.net into https://vault.azure.net/.default
+func scopeOfResource(resource string) string {
+	return strings.TrimSuffix(resource, "/") + "/.default"
+}
@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/samber/lo"
+	"golang.org/x/exp/slog"
+)
+
+// Versions of the api that are accepted, newest first
+var apiVersions = []string{
+	"2023-07-01", "2023-05-02", "2021-12-13", "2021-11-15", "2021-11-01", "2021-10-01",
+	"2021-05-01", "2021-03-01", "2021-02-01", "2021-01-01", "2020-12-01", "2020-10-01",
+	"2020-09-01", "2020-07-15", "2020-06-01", "2019-11-01", "2019-08-15", "2019-08-01",
+	"2019-06-04", "2019-06-01", "2019-04-30", "2019-03-11", "2019-02-01", "2018-10-01",
+	"2018-04-02", "2018-02-01", "2017-12-01", "2017-10-01", "2017-08-01", "2017-04-02",
+	"2017-03-01",
+}
+
+type metadataError struct {
+	Error          string   `json:"error"`
+	NewestVersions []string `json:"newest-versions,omitempty"`
+}
+
+func Routes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(verifyRequestHeaders)
+	r.Use(verifyApiVersion)
+	r.Get("/metadata/instance", instanceMetadata)
+	r.Get("/metadata/instance/*", instanceMetadata)
+	r.Get("/metadata/identity/oauth2/token", identityToken)
+	return r
+}
+
+// verifyRequestHeaders requires the Metadata header, which can't be set by requests forged
+// through a browser or server-side request forgery, and rejects forwarded requests.
+func verifyRequestHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("azure metadata request", "path", r.URL.Path)
+		if !strings.EqualFold(r.Header.Get("Metadata"), "true") {
+			writeError(w, r, http.StatusBadRequest, metadataError{
+				Error: "Bad request. Required metadata header not specified",
+			})
+			return
+		}
+		if r.Header.Get("X-Forwarded-For") != "" {
+			writeError(w, r, http.StatusBadRequest, metadataError{
+				Error: "Bad request. Forwarded requests are not allowed",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func verifyApiVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiVersion := r.URL.Query().Get("api-version")
+		if apiVersion == "" {
+			writeError(w, r, http.StatusBadRequest, metadataError{
+				Error:          "Bad request. api-version was not specified in the request. For more information refer to aka.ms/azureimds",
+				NewestVersions: apiVersions[:3],
+			})
+			return
+		}
+		if !lo.Contains(apiVersions, apiVersion) {
+			writeError(w, r, http.StatusBadRequest, metadataError{
+				Error:          "Bad request. api-version is invalid. For more information refer to aka.ms/azureimds",
+				NewestVersions: apiVersions[:3],
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, body any) {
+	render.Status(r, status)
+	render.JSON(w, r, body)
+}
@@ -0,0 +1,222 @@
+package azure
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Used when neither config nor the node tells us otherwise
+var defaultLocation = "westeurope"
+var defaultVmSize = "Standard_D4s_v5"
+
+// instanceMetadata serves the instance document, or the part of it named by the path below /metadata/instance.
+// Leaves can be requested as text with format=text.
+func instanceMetadata(w http.ResponseWriter, r *http.Request) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		slog.Error("failed to get calling pod", "err", err)
+		writeError(w, r, http.StatusNotFound, metadataError{Error: "Not found"})
+		return
+	}
+	node, err := kubernetes.NodeForPod(pod)
+	if err != nil {
+		slog.Debug("no node found for pod", "pod", pod.Name, "err", err)
+		node = nil
+	}
+
+	var document any = instanceDocument(pod, node)
+	for _, name := range strings.Split(strings.Trim(chi.URLParam(r, "*"), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var ok bool
+		if document, ok = child(document, name); !ok {
+			writeError(w, r, http.StatusNotFound, metadataError{Error: "Not found"})
+			return
+		}
+	}
+
+	if r.URL.Query().Get("format") != "text" {
+		render.JSON(w, r, document)
+		return
+	}
+	switch leaf := document.(type) {
+	case map[string]any, []any:
+		writeError(w, r, http.StatusBadRequest, metadataError{Error: "Bad request. The format text is only supported for leaf nodes"})
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(fmt.Sprint(leaf))) //nolint:errcheck
+	}
+}
+
+func child(document any, name string) (any, bool) {
+	switch node := document.(type) {
+	case map[string]any:
+		value, ok := node[name]
+		return value, ok
+	case []any:
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 0 || index >= len(node) {
+			return nil, false
+		}
+		return node[index], true
+	}
+	return nil, false
+}
+
+// instanceDocument describes the node running the pod as the vm, with the address of the pod.
+func instanceDocument(pod *corev1.Pod, node *corev1.Node) map[string]any {
+	name := vmName(node)
+	location, zone := placement(node)
+
+	vmSize := defaultVmSize
+	osType := "Linux"
+	vmId := derivedUuid(config.Current.Azure.SubscriptionId + "/" + name)
+	if node != nil {
+		vmSize = vmSizeForNode(node)
+		vmId = string(node.UID)
+		if node.Status.NodeInfo.OperatingSystem == "windows" {
+			osType = "Windows"
+		}
+	}
+	if config.Current.Instance.MachineType != "" {
+		vmSize = config.Current.Instance.MachineType
+	}
+
+	return map[string]any{
+		"compute": map[string]any{
+			"azEnvironment": "AzurePublicCloud",
+			"location":      location,
+			"name":          name,
+			"osProfile": map[string]any{
+				"adminUsername":                 "azureuser",
+				"computerName":                  name,
+				"disablePasswordAuthentication": "true",
+			},
+			"osType":            osType,
+			"provider":          "Microsoft.Compute",
+			"resourceGroupName": config.Current.Azure.ResourceGroup,
+			"resourceId": fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+				config.Current.Azure.SubscriptionId, config.Current.Azure.ResourceGroup, name),
+			"subscriptionId": config.Current.Azure.SubscriptionId,
+			"tags":           "",
+			"tagsList":       []any{},
+			"vmId":           vmId,
+			"vmSize":         vmSize,
+			"zone":           zone,
+		},
+		"network": map[string]any{
+			"interface": []any{networkInterface(pod, node)},
+		},
+	}
+}
+
+func networkInterface(pod *corev1.Pod, node *corev1.Node) map[string]any {
+	ipv4Addresses := []any{}
+	ipv4Subnets := []any{}
+	ipv6Addresses := []any{}
+	var ipv4 netip.Addr
+	for _, podIp := range kubernetes.PodIps(pod) {
+		addr, err := netip.ParseAddr(podIp)
+		if err != nil {
+			continue
+		}
+		if addr.Is6() {
+			ipv6Addresses = append(ipv6Addresses, map[string]any{"privateIpAddress": addr.String()})
+			continue
+		}
+		if !ipv4.IsValid() {
+			ipv4 = addr
+		}
+		ipv4Addresses = append(ipv4Addresses, map[string]any{
+			"privateIpAddress": addr.String(),
+			"publicIpAddress":  kubernetes.NodeAddress(node, corev1.NodeExternalIP),
+		})
+	}
+	if ipv4.IsValid() {
+		subnet := kubernetes.PodSubnet(node, ipv4)
+		ipv4Subnets = append(ipv4Subnets, map[string]any{
+			"address": subnet.Addr().String(),
+			"prefix":  strconv.Itoa(subnet.Bits()),
+		})
+	}
+
+	return map[string]any{
+		"ipv4": map[string]any{
+			"ipAddress": ipv4Addresses,
+			"subnet":    ipv4Subnets,
+		},
+		"ipv6": map[string]any{
+			"ipAddress": ipv6Addresses,
+		},
+		"macAddress": macAddress(pod, ipv4),
+	}
+}
+
+// macAddress uses the three byte prefix of Azure followed by the last three bytes of the ip of the pod,
+// or else of its uid. Only pods whose ips differ in the first byte alone share an address,
+// which a pod network smaller than a /8 rules out. Like on Azure, it is written without separators.
+func macAddress(pod *corev1.Pod, ipv4 netip.Addr) string {
+	var suffix [3]byte
+	if ipv4.IsValid() {
+		ip := ipv4.As4()
+		suffix = [3]byte{ip[1], ip[2], ip[3]}
+	} else {
+		id := kubernetes.NumericId(string(pod.UID))
+		suffix = [3]byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	}
+	return fmt.Sprintf("000D3A%02X%02X%02X", suffix[0], suffix[1], suffix[2])
+}
+
+// derivedUuid formats a stable uuid from the seed
+func derivedUuid(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func vmName(node *corev1.Node) string {
+	if config.Current.Instance.Name != "" {
+		return config.Current.Instance.Name
+	}
+	if node != nil {
+		return node.Name
+	}
+	return "vm0"
+}
+
+// placement resolves the location and zone from config or the topology labels of the node.
+// Zones are named <location>-<number> in the labels, and served as just the number.
+func placement(node *corev1.Node) (string, string) {
+	zone := config.Current.Instance.Zone
+	region := ""
+	if node != nil {
+		if zone == "" {
+			zone = node.Labels[kubernetes.ZoneLabel]
+		}
+		region = node.Labels[kubernetes.RegionLabel]
+	}
+
+	if location, number, ok := strings.Cut(zone, "-"); ok {
+		if _, err := strconv.Atoi(number); err == nil {
+			return location, number
+		}
+	}
+	if region != "" {
+		return region, ""
+	}
+	if zone != "" {
+		return zone, ""
+	}
+	return defaultLocation, ""
+}
@@ -0,0 +1,41 @@
+package azure
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var vmSizes = []int64{2, 4, 8, 16, 32, 48, 64, 96}
+
+// vmSizeForNode picks the vm size closest to the capacity of the node.
+// Single cpu nodes map to B sizes, larger ones to F, D or E sizes by memory per cpu.
+func vmSizeForNode(node *corev1.Node) string {
+	cpus := node.Status.Capacity.Cpu().Value()
+	memoryGiB := float64(node.Status.Capacity.Memory().Value()) / (1 << 30)
+
+	if cpus <= 1 {
+		if memoryGiB <= 1 {
+			return "Standard_B1s"
+		}
+		return "Standard_B1ms"
+	}
+
+	series := "Standard_D%ds_v5"
+	switch perCpu := memoryGiB / float64(cpus); {
+	case perCpu < 3:
+		series = "Standard_F%ds_v2"
+	case perCpu >= 6:
+		series = "Standard_E%ds_v5"
+	}
+
+	size := vmSizes[len(vmSizes)-1]
+	for _, s := range vmSizes {
+		if s >= cpus {
+			size = s
+			break
+		}
+	}
+
+	return fmt.Sprintf(series, size)
+}
@@ -2,40 +2,30 @@ package google
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/go-chi/chi"
-	"github.com/magnm/lcm/pkg/routes/util"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/routes/tree"
 )
 
-func computeMetadataRoutes(r chi.Router) {
-	r.Get("/", metadataIndex)
-	r.Get("/project", util.RedirectTo("/project/"))
-	r.Get("/project/", project)
-	r.Get("/project/project-id", projectId)
-	r.Get("/project/numeric-project-id", projectNumericId)
+func computeMetadataRoutes(root *tree.Node) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/*", metadataHandler(root))
+		guestAttributesRoutes(r)
+	}
+}
 
-	r.Get("/instance", util.RedirectTo("/instance/"))
-	r.Get("/instance/", instance)
-	r.Get("/instance/hostname", instanceHostname)
-	r.Get("/instance/id", instanceId)
-	r.Get("/instance/zone", instanceZone)
-	r.Get("/instance/attributes", util.RedirectTo("/instance/attributes/"))
-	r.Get("/instance/attributes/", instanceAttributes)
-	r.Get("/instance/attributes/cluster-location", instanceClusterLocation)
-	r.Get("/instance/attributes/cluster-name", instanceClusterName)
-	r.Get("/instance/attributes/cluster-uid", instanceClusterUid)
-	r.Get("/instance/service-accounts", util.RedirectTo("/instance/service-accounts/"))
-	r.Get("/instance/service-accounts/", serviceAccounts)
-	r.Get("/instance/service-accounts/{acc}", util.RedirectToKey("/instance/service-accounts/{acc}/", []string{"acc"}))
-	r.Get("/instance/service-accounts/{acc}/", serviceAccount)
-	r.Get("/instance/service-accounts/{acc}/{key}", serviceAccountAttr)
+// metadataRoot is the tree served below /computeMetadata/v1/ and v1beta1
+func metadataRoot() *tree.Node {
+	return tree.Directory(
+		tree.Child("instance", instanceNode()),
+		tree.Child("project", projectNode()),
+		tree.Child("universe", tree.Directory(
+			tree.Child("universe-domain", tree.Value(universeDomain)),
+		)),
+	)
 }
 
-func metadataIndex(w http.ResponseWriter, r *http.Request) {
-	paths := []string{
-		"project/",
-		"instance/",
-	}
-	writeText(w, r, strings.Join(paths, "\n"))
+func universeDomain(r *http.Request) (any, error) {
+	return config.Current.Google.UniverseDomain, nil
 }
@@ -0,0 +1,95 @@
+package google
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnm/lcm/pkg/faults"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"golang.org/x/exp/slog"
+)
+
+// injectFaults degrades responses to pods with the faults annotation,
+// so that clients can be tested against an unreliable metadata server.
+// Requests from other pods pass through untouched.
+func injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pod, err := kubernetes.LookupCallingPod(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		podFaults, err := faults.ForPod(pod)
+		if err != nil {
+			slog.Error("invalid faults annotation, ignoring", "namespace", pod.Namespace, "pod", pod.Name, "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if podFaults == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay := time.Duration(podFaults.Latency)
+		if r.URL.Query().Get("wait_for_change") == "true" {
+			delay += time.Duration(podFaults.LongPollDelay)
+		}
+		if delay > 0 && !sleep(r, delay) {
+			return
+		}
+
+		switch {
+		case faults.Roll(podFaults.UnavailableRate):
+			slog.Debug("injecting unavailable response", "pod", pod.Name, "path", r.URL.Path)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		case faults.Roll(podFaults.ErrorRate):
+			slog.Debug("injecting error response", "pod", pod.Name, "path", r.URL.Path)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		case isTokenRequest(r) && faults.Roll(podFaults.TokenErrorRate):
+			slog.Debug("injecting token error", "pod", pod.Name, "path", r.URL.Path)
+			http.Error(w, "failed to get access token", http.StatusInternalServerError)
+			return
+		case faults.Roll(podFaults.TruncateRate):
+			slog.Debug("injecting truncated response", "pod", pod.Name, "path", r.URL.Path)
+			response := &bufferedResponse{header: http.Header{}}
+			next.ServeHTTP(response, r)
+			writeTruncated(w, response)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeTruncated announces the full body but only sends half of it, then drops the connection.
+func writeTruncated(w http.ResponseWriter, response *bufferedResponse) {
+	body := response.body.Bytes()
+	response.header.Set("Content-Length", strconv.Itoa(len(body)))
+	response.body.Truncate(len(body) / 2)
+	response.writeTo(w)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func isTokenRequest(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, "/service-accounts/") &&
+		(strings.HasSuffix(r.URL.Path, "/token") || strings.HasSuffix(r.URL.Path, "/identity"))
+}
+
+// sleep waits for the duration, returning false if the request was cancelled meanwhile.
+func sleep(r *http.Request, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,140 @@
+package google
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/go-chi/chi"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubegoogle "github.com/magnm/lcm/pkg/kubernetes/google"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"github.com/samber/lo"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type guestAttributes map[string]map[string]string
+
+var guestAttributeName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Serializes read-modify-write of the annotation
+var guestAttributesLock sync.Mutex
+
+func guestAttributesRoutes(r chi.Router) {
+	r.Put("/instance/guest-attributes/{namespace}/{key}", putGuestAttribute)
+	r.Delete("/instance/guest-attributes/{namespace}/{key}", deleteGuestAttribute)
+}
+
+// guestAttributesNode serves the attributes written by the calling pod, grouped by namespace.
+func guestAttributesNode() *tree.Node {
+	namespaces := tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		pod, err := kubernetes.CallingPod(r)
+		if err != nil {
+			slog.Error("failed to get calling pod", "err", err)
+			return nil, tree.NotFound()
+		}
+
+		attributes := podGuestAttributes(pod)
+		names := lo.Keys(attributes)
+		sort.Strings(names)
+		return lo.Map(names, func(name string, i int) tree.Entry {
+			keys := lo.Keys(attributes[name])
+			sort.Strings(keys)
+			namespace := tree.Directory(lo.Map(keys, func(key string, i int) tree.Entry {
+				return tree.Child(key, tree.StaticValue(attributes[name][key]))
+			})...)
+			namespace.RawKeys = true
+			return tree.Child(name, namespace)
+		}), nil
+	})
+	namespaces.RawKeys = true
+	return namespaces
+}
+
+func putGuestAttribute(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	value := string(body)
+
+	updateGuestAttributes(w, r, func(attributes guestAttributes, namespace string, key string) {
+		if attributes[namespace] == nil {
+			attributes[namespace] = map[string]string{}
+		}
+		attributes[namespace][key] = value
+	})
+}
+
+func deleteGuestAttribute(w http.ResponseWriter, r *http.Request) {
+	updateGuestAttributes(w, r, func(attributes guestAttributes, namespace string, key string) {
+		delete(attributes[namespace], key)
+		if len(attributes[namespace]) == 0 {
+			delete(attributes, namespace)
+		}
+	})
+}
+
+func updateGuestAttributes(w http.ResponseWriter, r *http.Request, update func(attributes guestAttributes, namespace string, key string)) {
+	namespace := chi.URLParam(r, "namespace")
+	key := chi.URLParam(r, "key")
+	if !guestAttributeName.MatchString(namespace) || !guestAttributeName.MatchString(key) {
+		http.Error(w, "invalid guest attribute namespace or key", http.StatusBadRequest)
+		return
+	}
+
+	calling, err := kubernetes.CallingPod(r)
+	if err != nil {
+		slog.Error("failed to get calling pod", "err", err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	guestAttributesLock.Lock()
+	defer guestAttributesLock.Unlock()
+
+	// The cached pod may lag behind a previous write
+	pod, err := kubernetes.GetPod(calling.Namespace, calling.Name)
+	if err != nil {
+		slog.Error("failed to get pod", "pod", calling.Name, "err", err)
+		http.Error(w, "failed to get pod", http.StatusInternalServerError)
+		return
+	}
+
+	attributes := podGuestAttributes(pod)
+	update(attributes, namespace, key)
+
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		http.Error(w, "failed to encode guest attributes", http.StatusInternalServerError)
+		return
+	}
+	annotation := string(encoded)
+	err = kubernetes.PatchPodAnnotations(pod.Namespace, pod.Name, map[string]*string{
+		kubegoogle.GuestAttributesAnnotation: &annotation,
+	})
+	if err != nil {
+		slog.Error("failed to store guest attributes", "pod", pod.Name, "err", err)
+		http.Error(w, "failed to store guest attributes", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Debug("updated guest attributes", "pod", pod.Name, "namespace", namespace, "key", key)
+	writeText(w, r, "")
+}
+
+func podGuestAttributes(pod *corev1.Pod) guestAttributes {
+	attributes := guestAttributes{}
+	if encoded, ok := pod.Annotations[kubegoogle.GuestAttributesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(encoded), &attributes); err != nil {
+			slog.Warn("ignoring invalid guest attributes annotation", "pod", pod.Name, "err", err)
+			return guestAttributes{}
+		}
+	}
+	return attributes
+}
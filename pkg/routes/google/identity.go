@@ -0,0 +1,82 @@
+package google
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/jwt"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"golang.org/x/exp/slog"
+)
+
+var identityTokenLifetime = time.Hour
+
+var identitySigner *jwt.Signer
+var identitySignerOnce sync.Once
+
+// signIdentityToken issues an identity token with lcm's own key.
+// With format=full, the compute_engine claims are built from the instance of the calling pod, as resolved into the key.
+func signIdentityToken(r *http.Request, signer *jwt.Signer, key identityCacheKey) (string, error) {
+	subject := fmt.Sprint(kubernetes.NumericId(key.Email))
+	now := time.Now().UTC()
+
+	claims := map[string]any{
+		"aud":            key.Audience,
+		"azp":            subject,
+		"email":          key.Email,
+		"email_verified": true,
+		"exp":            now.Add(identityTokenLifetime).Unix(),
+		"iat":            now.Unix(),
+		"iss":            config.Current.Google.IdentityTokenIssuer,
+		"sub":            subject,
+	}
+
+	if key.Format == "full" {
+		projectNumber, err := projectNumericId(r)
+		if err != nil {
+			return "", err
+		}
+
+		computeEngine := map[string]any{
+			"instance_id":    key.InstanceId,
+			"instance_name":  key.InstanceName,
+			"project_id":     config.Current.ProjectId,
+			"project_number": projectNumber,
+			"zone":           key.Zone,
+		}
+		if node, err := callingNode(r); err == nil {
+			computeEngine["instance_creation_timestamp"] = node.CreationTimestamp.Unix()
+		}
+		if key.IncludeLicenses {
+			licenseIds := config.Current.Google.LicenseIds
+			if licenseIds == nil {
+				licenseIds = []string{}
+			}
+			computeEngine["license_id"] = licenseIds
+		}
+		claims["google"] = map[string]any{
+			"compute_engine": computeEngine,
+		}
+	}
+
+	return signer.Sign(claims)
+}
+
+func getIdentitySigner() *jwt.Signer {
+	identitySignerOnce.Do(func() {
+		keyFile := config.Current.Google.IdentityTokenKey
+		if keyFile == "" {
+			return
+		}
+		signer, err := jwt.LoadSigner(keyFile)
+		if err != nil {
+			slog.Error("failed to load identity token signing key", "err", err)
+			return
+		}
+		identitySigner = signer
+	})
+	return identitySigner
+}
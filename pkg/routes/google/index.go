@@ -2,6 +2,7 @@ package google
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
@@ -9,31 +10,49 @@ import (
 	"golang.org/x/exp/slog"
 )
 
+// Older client libraries still use v1beta1, the same data is served for all versions
+var metadataVersions = []string{"v1", "v1beta1"}
+
 func Routes() *chi.Mux {
+	root := metadataRoot()
+
 	r := chi.NewRouter()
 	r.Use(verifyRequestHeaders)
+	r.Use(injectFaults)
+	r.Use(waitForChange)
 	r.Get("/", index)
 	r.Get("/computeMetadata", util.RedirectTo("/computeMetadata/v1/"))
-	r.Get("/computeMetadata/v1", util.RedirectTo("/computeMetadata/v1/"))
-	r.Route("/computeMetadata/v1/", computeMetadataRoutes)
+	for _, version := range metadataVersions {
+		r.Get("/computeMetadata/"+version, util.RedirectTo("/computeMetadata/"+version+"/"))
+		r.Route("/computeMetadata/"+version+"/", computeMetadataRoutes(root))
+	}
+	r.Get("/0.1", util.RedirectTo("/0.1/"))
+	r.Get("/0.1/", legacyIndex)
+	r.Get("/0.1/meta-data", util.RedirectTo("/0.1/meta-data/"))
+	r.Route("/0.1/meta-data/", legacyMetadataRoutes(legacyMetadataRoot()))
 	return r
 }
 
 func index(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, "computeMetadata/")
+	paths := []string{
+		"0.1/",
+		"computeMetadata/",
+		"",
+	}
+	writeText(w, r, strings.Join(paths, "\n"))
 }
 
 func verifyRequestHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		slog.Debug("google metadata request", "path", r.URL.Path)
-		// Check Metadata-Flavor
-		header := r.Header.Get("Metadata-Flavor")
-		if header != "Google" {
+		// Check Metadata-Flavor, or the header used by legacy clients
+		if r.Header.Get("Metadata-Flavor") != "Google" &&
+			!strings.EqualFold(r.Header.Get("X-Google-Metadata-Request"), "True") {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 		// Check no X-Forwarded-For
-		header = r.Header.Get("X-Forwarded-For")
+		header := r.Header.Get("X-Forwarded-For")
 		if header != "" {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
@@ -3,57 +3,312 @@ package google
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/magnm/lcm/config"
 	googleclient "github.com/magnm/lcm/pkg/cloud/client/google"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	kubegoogle "github.com/magnm/lcm/pkg/kubernetes/google"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"github.com/samber/lo"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
 )
 
-func instance(w http.ResponseWriter, r *http.Request) {
-	paths := []string{
-		"attributes/",
-		"hostname",
-		"id",
-		"service-accounts/",
-		"zone",
+// Used when neither config nor the environment tells us otherwise
+var defaultZone = "europe-west1-d"
+var defaultClusterName = "dev-cluster"
+var defaultMachineType = "e2-standard-4"
+var defaultCpuPlatform = "Intel Broadwell"
+var defaultImage = "cos-stable"
+
+func instanceNode() *tree.Node {
+	return tree.Directory(
+		tree.Child("attributes", instanceAttributesNode()),
+		tree.Child("cpu-platform", tree.Value(instanceCpuPlatform)),
+		tree.Child("description", tree.Value(instanceDescription)),
+		tree.Child("gce-workload-certificates", workloadCertificatesNode()),
+		tree.Child("guest-attributes", guestAttributesNode()),
+		tree.Child("hostname", tree.Value(instanceHostname)),
+		tree.Child("id", tree.Value(instanceId)),
+		tree.Child("image", tree.Value(instanceImage)),
+		tree.Child("machine-type", tree.Value(instanceMachineType)),
+		tree.Child("maintenance-event", tree.Value(instanceMaintenanceEvent)),
+		tree.Child("name", tree.Value(instanceName)),
+		tree.Child("network-interfaces", networkInterfacesNode()),
+		tree.Child("preempted", tree.Value(instancePreempted)),
+		tree.Child("service-accounts", serviceAccountsNode()),
+		tree.Child("tags", tree.Value(instanceTags)),
+		tree.Child("zone", tree.Value(instanceZone)),
+	)
+}
+
+// instanceAttributesNode serves the cluster attributes, merged with the
+// attributes the calling pod declares through its annotations.
+func instanceAttributesNode() *tree.Node {
+	static := map[string]*tree.Node{
+		"cluster-location": tree.Value(instanceClusterLocation),
+		"cluster-name":     tree.Value(instanceClusterName),
+		"cluster-uid":      tree.Value(instanceClusterUid),
+		"kube-env":         tree.Value(instanceKubeEnv),
+		"kube-labels":      tree.Value(instanceKubeLabels),
+	}
+
+	attributes := tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		nodes := map[string]*tree.Node{}
+		for key, node := range static {
+			nodes[key] = node
+		}
+
+		if pod, err := kubernetes.CallingPod(r); err == nil {
+			for key, val := range kubernetes.PodAnnotationsWithPrefix(pod, kubernetes.AttributeAnnotationPrefix) {
+				nodes[key] = tree.StaticValue(val)
+			}
+		} else {
+			slog.Debug("no pod attributes for request", "err", err)
+		}
+
+		keys := lo.Keys(nodes)
+		sort.Strings(keys)
+		return lo.Map(keys, func(key string, i int) tree.Entry {
+			return tree.Child(key, nodes[key])
+		}), nil
+	})
+	attributes.RawKeys = true
+	return attributes
+}
+
+func instanceHostname(r *http.Request) (any, error) {
+	return fmt.Sprintf("%s.c.%s.internal", nameOfInstance(r), config.Current.ProjectId), nil
+}
+
+func instanceName(r *http.Request) (any, error) {
+	return nameOfInstance(r), nil
+}
+
+func instanceDescription(r *http.Request) (any, error) {
+	return config.Current.Instance.Description, nil
+}
+
+func instanceMachineType(r *http.Request) (any, error) {
+	machineType := config.Current.Instance.MachineType
+	if machineType == "" {
+		machineType = defaultMachineType
+		if node, err := callingNode(r); err == nil {
+			machineType = machineTypeForNode(node)
+		}
+	}
+
+	numericId, err := projectNumber()
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", numericId, zoneName(r), machineType), nil
+}
+
+func instanceCpuPlatform(r *http.Request) (any, error) {
+	if config.Current.Instance.CpuPlatform != "" {
+		return config.Current.Instance.CpuPlatform, nil
+	}
+	if node, err := callingNode(r); err == nil {
+		return cpuPlatformForNode(node), nil
+	}
+	return defaultCpuPlatform, nil
+}
+
+// instanceImage names an image after the os of the node, unless configured.
+func instanceImage(r *http.Request) (any, error) {
+	if config.Current.Instance.Image != "" {
+		return config.Current.Instance.Image, nil
+	}
+	osImage := defaultImage
+	if node, err := callingNode(r); err == nil && node.Status.NodeInfo.OSImage != "" {
+		osImage = node.Status.NodeInfo.OSImage
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", config.Current.ProjectId, resourceName(osImage)), nil
+}
+
+func instanceMaintenanceEvent(r *http.Request) (any, error) {
+	return hostEvent(r, kubegoogle.MaintenanceEventAnnotation, "NONE"), nil
+}
+
+func instancePreempted(r *http.Request) (any, error) {
+	return hostEvent(r, kubegoogle.PreemptedAnnotation, "FALSE"), nil
+}
+
+// hostEvent reads a simulated host event from the calling pod, or else from the node running it.
+func hostEvent(r *http.Request, annotation string, fallback string) string {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		return fallback
+	}
+	if event, ok := pod.Annotations[annotation]; ok {
+		return event
+	}
+	if node, err := kubernetes.NodeForPod(pod); err == nil {
+		if event, ok := node.Annotations[annotation]; ok {
+			return event
+		}
 	}
-	writeText(w, r, strings.Join(paths, "\n"))
+	return fallback
 }
 
-func instanceHostname(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, fmt.Sprintf("node0.c.%s.internal", config.Current.ProjectId))
+// instanceTags are the configured tags, or those declared through labels on the node.
+// Like on GCE, they are served as a json array.
+func instanceTags(r *http.Request) (any, error) {
+	if len(config.Current.Instance.Tags) > 0 {
+		return jsonArray(config.Current.Instance.Tags), nil
+	}
+	tags := []string{}
+	if node, err := callingNode(r); err == nil {
+		tags = kubernetes.NodeLabelsWithPrefix(node, kubernetes.TagLabelPrefix)
+		sort.Strings(tags)
+	}
+	return jsonArray(tags), nil
 }
 
-func instanceId(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, "1234567890")
+func instanceId(r *http.Request) (any, error) {
+	if config.Current.Instance.Id != 0 {
+		return config.Current.Instance.Id, nil
+	}
+	if node, err := callingNode(r); err == nil {
+		return kubernetes.NumericId(string(node.UID)), nil
+	}
+	if node, err := kubernetes.OurNode(); err == nil {
+		return kubernetes.NumericId(string(node.UID)), nil
+	}
+	return kubernetes.NumericId(config.Current.ProjectId + "/" + clusterName()), nil
 }
 
-func instanceZone(w http.ResponseWriter, r *http.Request) {
+func instanceZone(r *http.Request) (any, error) {
 	project := googleclient.GetProject(config.Current.ProjectId)
 	if project == nil {
-		http.Error(w, "failed to get project", http.StatusInternalServerError)
-		return
+		return nil, tree.NewError(http.StatusInternalServerError, "failed to get project")
+	}
+	return project.Name + "/zones/" + zoneName(r), nil
+}
+
+func instanceClusterLocation(r *http.Request) (any, error) {
+	if config.Current.Instance.ClusterLocation != "" {
+		return config.Current.Instance.ClusterLocation, nil
+	}
+	return kubernetes.RegionOfZone(zoneName(r)), nil
+}
+
+func instanceClusterName(r *http.Request) (any, error) {
+	return clusterName(), nil
+}
+
+func instanceClusterUid(r *http.Request) (any, error) {
+	if config.Current.Instance.ClusterUid != "" {
+		return config.Current.Instance.ClusterUid, nil
+	}
+	uid, err := kubernetes.ClusterUid()
+	if err != nil {
+		slog.Error("failed to get cluster uid", "err", err)
+		return nil, tree.NewError(http.StatusInternalServerError, "failed to get cluster uid")
+	}
+	return uid, nil
+}
+
+// instanceKubeLabels lists the labels of the node running the calling pod, like on GKE nodes.
+func instanceKubeLabels(r *http.Request) (any, error) {
+	node, err := callingNode(r)
+	if err != nil {
+		return "", nil
 	}
-	writeText(w, r, project.Name+"/zones/eu-west1-d")
+	return kubernetes.NodeLabels(node), nil
 }
 
-func instanceAttributes(w http.ResponseWriter, r *http.Request) {
-	paths := []string{
-		"cluster-location",
-		"cluster-name",
-		"cluster-uid",
+// instanceKubeEnv describes the node running the calling pod, one KEY: value per line
+// like the kube-env attribute of GKE nodes, though with far fewer keys.
+func instanceKubeEnv(r *http.Request) (any, error) {
+	env := map[string]string{
+		"CLUSTER_NAME": clusterName(),
+		"ZONE":         zoneName(r),
 	}
-	writeText(w, r, strings.Join(paths, "\n"))
+	if uid, err := kubernetes.ClusterUid(); err == nil {
+		env["CLUSTER_UID"] = uid
+	}
+	if node, err := callingNode(r); err == nil {
+		env["NODE_NAME"] = node.Name
+		env["NODE_LABELS"] = kubernetes.NodeLabels(node)
+		env["KUBELET_VERSION"] = node.Status.NodeInfo.KubeletVersion
+		env["CONTAINER_RUNTIME"] = node.Status.NodeInfo.ContainerRuntimeVersion
+		env["OS_IMAGE"] = node.Status.NodeInfo.OSImage
+		env["ARCHITECTURE"] = node.Status.NodeInfo.Architecture
+	}
+
+	keys := lo.Keys(env)
+	sort.Strings(keys)
+	return strings.Join(lo.Map(keys, func(key string, i int) string {
+		return key + ": " + env[key] + "\n"
+	}), ""), nil
 }
 
-func instanceClusterLocation(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, "europe-west1")
+// zoneName resolves the zone from config, the topology of the node running the calling pod,
+// the topology of the node lcm runs on, or the configured cluster location if that is a zone.
+func zoneName(r *http.Request) string {
+	if config.Current.Instance.Zone != "" {
+		return config.Current.Instance.Zone
+	}
+	if node, err := callingNode(r); err == nil {
+		if zone := kubernetes.ZoneOfNode(node); zone != "" {
+			return zone
+		}
+	}
+	if node, err := kubernetes.OurNode(); err == nil {
+		if zone := kubernetes.ZoneOfNode(node); zone != "" {
+			return zone
+		}
+	}
+	if location := config.Current.Instance.ClusterLocation; location != kubernetes.RegionOfZone(location) {
+		return location
+	}
+	return defaultZone
 }
 
-func instanceClusterName(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, "dev-cluster")
+func nameOfInstance(r *http.Request) string {
+	if config.Current.Instance.Name != "" {
+		return config.Current.Instance.Name
+	}
+	if node, err := callingNode(r); err == nil {
+		return node.Name
+	}
+	return "node0"
 }
 
-func instanceClusterUid(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, "9876543210")
+// resourceName turns text into a valid resource name, lowercase letters, digits and hyphens.
+func resourceName(text string) string {
+	name := strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			return c
+		}
+		return '-'
+	}, strings.ToLower(text))
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	return strings.Trim(name, "-")
+}
+
+// clusterName is the configured name, the one detected from the cluster, or the default.
+func clusterName() string {
+	if config.Current.Instance.ClusterName != "" {
+		return config.Current.Instance.ClusterName
+	}
+	if name := kubernetes.ClusterName(); name != "" {
+		return name
+	}
+	return defaultClusterName
+}
+
+// callingNode returns the node running the calling pod, which is the "instance" it sees.
+func callingNode(r *http.Request) (*corev1.Node, error) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NodeForPod(pod)
 }
@@ -0,0 +1,37 @@
+package google
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/magnm/lcm/pkg/routes/tree"
+)
+
+func legacyMetadataRoutes(root *tree.Node) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/*", metadataHandler(root))
+	}
+}
+
+func legacyIndex(w http.ResponseWriter, r *http.Request) {
+	writeText(w, r, "meta-data/\n")
+}
+
+// legacyMetadataRoot is the tree served below /0.1/meta-data/, the flat layout of the metadata server before v1.
+// It maps the legacy paths onto the same data as the current tree.
+func legacyMetadataRoot() *tree.Node {
+	return tree.Directory(
+		tree.Child("attributes", instanceAttributesNode()),
+		tree.Child("description", tree.Value(instanceDescription)),
+		tree.Child("hostname", tree.Value(instanceHostname)),
+		tree.Child("image", tree.Value(instanceImage)),
+		tree.Child("instance-id", tree.Value(instanceId)),
+		tree.Child("machine-type", tree.Value(instanceMachineType)),
+		tree.Child("network", tree.Value(instanceNetwork)),
+		tree.Child("numeric-project-id", tree.Value(projectNumericId)),
+		tree.Child("project-id", tree.Value(projectId)),
+		tree.Child("service-accounts", serviceAccountsNode()),
+		tree.Child("tags", tree.Value(instanceTags)),
+		tree.Child("zone", tree.Value(instanceZone)),
+	)
+}
@@ -0,0 +1,63 @@
+package google
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var e2Sizes = []int64{2, 4, 8, 16, 32}
+var n2Sizes = []int64{2, 4, 8, 16, 32, 48, 64, 80, 96, 128}
+
+// machineTypeForNode picks the predefined machine type closest to the capacity of the node.
+// Nodes up to 32 cpus map to e2 types, larger ones to n2.
+func machineTypeForNode(node *corev1.Node) string {
+	cpus := node.Status.Capacity.Cpu().Value()
+	memoryGiB := float64(node.Status.Capacity.Memory().Value()) / (1 << 30)
+
+	if cpus <= 1 {
+		if memoryGiB <= 2 {
+			return "e2-small"
+		}
+		return "e2-medium"
+	}
+
+	class := "standard"
+	switch perCpu := memoryGiB / float64(cpus); {
+	case perCpu < 2:
+		class = "highcpu"
+	case perCpu >= 6:
+		class = "highmem"
+	}
+
+	// e2 highmem types stop at 16 cpus
+	maxE2 := e2Sizes[len(e2Sizes)-1]
+	if class == "highmem" {
+		maxE2 = 16
+	}
+
+	family, sizes := "e2", e2Sizes
+	if cpus > maxE2 {
+		family, sizes = "n2", n2Sizes
+	}
+
+	size := sizes[len(sizes)-1]
+	for _, s := range sizes {
+		if s >= cpus {
+			size = s
+			break
+		}
+	}
+
+	return fmt.Sprintf("%s-%s-%d", family, class, size)
+}
+
+// cpuPlatformForNode names a cpu platform available for the machine types above.
+func cpuPlatformForNode(node *corev1.Node) string {
+	switch node.Status.NodeInfo.Architecture {
+	case "arm64":
+		return "Ampere Altra"
+	default:
+		return "Intel Broadwell"
+	}
+}
@@ -0,0 +1,63 @@
+package google
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func nodeWithCapacity(cpu string, memory string) *corev1.Node {
+	return &corev1.Node{
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+func TestMachineTypeForNode(t *testing.T) {
+	tests := []struct {
+		name   string
+		cpu    string
+		memory string
+		want   string
+	}{
+		{name: "small", cpu: "1", memory: "2Gi", want: "e2-small"},
+		{name: "medium", cpu: "1", memory: "4Gi", want: "e2-medium"},
+		{name: "fractional cpu", cpu: "500m", memory: "1Gi", want: "e2-small"},
+		{name: "standard", cpu: "4", memory: "16Gi", want: "e2-standard-4"},
+		{name: "standard with reserved memory", cpu: "4", memory: "15Gi", want: "e2-standard-4"},
+		{name: "highcpu", cpu: "8", memory: "8Gi", want: "e2-highcpu-8"},
+		{name: "highmem", cpu: "2", memory: "16Gi", want: "e2-highmem-2"},
+		{name: "rounded up to the next size", cpu: "3", memory: "12Gi", want: "e2-standard-4"},
+		{name: "largest e2", cpu: "32", memory: "128Gi", want: "e2-standard-32"},
+		{name: "n2 beyond e2", cpu: "48", memory: "192Gi", want: "n2-standard-48"},
+		{name: "highmem n2 beyond 16 cpus", cpu: "32", memory: "256Gi", want: "n2-highmem-32"},
+		{name: "larger than any type", cpu: "256", memory: "1024Gi", want: "n2-standard-128"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := machineTypeForNode(nodeWithCapacity(tt.cpu, tt.memory)); got != tt.want {
+				t.Errorf("machineTypeForNode(%s, %s) = %s, want %s", tt.cpu, tt.memory, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCpuPlatformForNode(t *testing.T) {
+	tests := map[string]string{
+		"amd64": "Intel Broadwell",
+		"arm64": "Ampere Altra",
+		"":      "Intel Broadwell",
+	}
+	for architecture, want := range tests {
+		node := &corev1.Node{Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{Architecture: architecture}}}
+		if got := cpuPlatformForNode(node); got != want {
+			t.Errorf("cpuPlatformForNode(%q) = %s, want %s", architecture, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package google
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Default MTU of a VPC network
+var networkMtu = 1460
+
+// networkInterfacesNode exposes the networking of the calling pod as the single nic of the instance.
+func networkInterfacesNode() *tree.Node {
+	interfaces := tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		pod, err := kubernetes.CallingPod(r)
+		if err != nil {
+			slog.Error("failed to get calling pod", "err", err)
+			return nil, tree.NotFound()
+		}
+
+		node, err := kubernetes.NodeForPod(pod)
+		if err != nil {
+			slog.Debug("no node found for pod", "pod", pod.Name, "err", err)
+			node = nil
+		}
+
+		return []tree.Entry{
+			tree.Child("0", networkInterfaceNode(pod, node)),
+		}, nil
+	})
+	interfaces.List = true
+	return interfaces
+}
+
+func networkInterfaceNode(pod *corev1.Pod, node *corev1.Node) *tree.Node {
+	var ipv4 netip.Addr
+	ipv6s := []netip.Addr{}
+	for _, podIp := range kubernetes.PodIps(pod) {
+		addr, err := netip.ParseAddr(podIp)
+		if err != nil {
+			continue
+		}
+		if addr.Is4() && !ipv4.IsValid() {
+			ipv4 = addr
+		} else if addr.Is6() {
+			ipv6s = append(ipv6s, addr)
+		}
+	}
+
+	accessConfigs := []tree.Entry{}
+	if externalIp := kubernetes.NodeAddress(node, corev1.NodeExternalIP); externalIp != "" {
+		accessConfigs = append(accessConfigs, tree.Child("0", tree.Directory(
+			tree.Child("external-ip", tree.StaticValue(externalIp)),
+			tree.Child("type", tree.StaticValue("ONE_TO_ONE_NAT")),
+		)))
+	}
+	accessConfigsNode := tree.Directory(accessConfigs...)
+	accessConfigsNode.List = true
+
+	entries := []tree.Entry{
+		tree.Child("access-configs", accessConfigsNode),
+	}
+	if ipv4.IsValid() {
+		subnet := kubernetes.PodSubnet(node, ipv4)
+		entries = append(entries, tree.Child("gateway", tree.StaticValue(subnet.Addr().Next().String())))
+	}
+	if len(ipv6s) > 0 {
+		subnet := kubernetes.PodSubnet(node, ipv6s[0])
+		entries = append(entries, tree.Child("gateway-ipv6", tree.StaticValue(subnet.Addr().Next().String())))
+	}
+	if ipv4.IsValid() {
+		entries = append(entries, tree.Child("ip", tree.StaticValue(ipv4.String())))
+	}
+	if len(ipv6s) > 0 {
+		addresses := []string{}
+		for _, addr := range ipv6s {
+			addresses = append(addresses, addr.String())
+		}
+		entries = append(entries, tree.Child("ipv6s", tree.StaticValue(addresses)))
+	}
+	entries = append(entries,
+		tree.Child("mac", tree.StaticValue(macAddress(pod, ipv4))),
+		tree.Child("mtu", tree.StaticValue(networkMtu)),
+		tree.Child("network", tree.Value(instanceNetwork)),
+	)
+	if ipv4.IsValid() {
+		subnet := kubernetes.PodSubnet(node, ipv4)
+		entries = append(entries, tree.Child("subnetmask", tree.StaticValue(net.IP(net.CIDRMask(subnet.Bits(), 32)).String())))
+	}
+
+	return tree.Directory(entries...)
+}
+
+func instanceNetwork(r *http.Request) (any, error) {
+	numericId, err := projectNumber()
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("projects/%s/networks/%s", numericId, config.Current.Instance.Network), nil
+}
+
+// macAddress follows GCE, which embeds the internal ip in the mac address.
+// Pods without an ipv4 address get one derived from their uid instead.
+func macAddress(pod *corev1.Pod, ipv4 netip.Addr) string {
+	var suffix [4]byte
+	if ipv4.IsValid() {
+		suffix = ipv4.As4()
+	} else {
+		id := kubernetes.NumericId(string(pod.UID))
+		suffix = [4]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	}
+	return fmt.Sprintf("42:01:%02x:%02x:%02x:%02x", suffix[0], suffix[1], suffix[2], suffix[3])
+}
@@ -2,32 +2,70 @@ package google
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/magnm/lcm/config"
 	googleclient "github.com/magnm/lcm/pkg/cloud/client/google"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"github.com/samber/lo"
+	"golang.org/x/exp/slog"
+	errorv1 "k8s.io/apimachinery/pkg/api/errors"
 )
 
-func project(w http.ResponseWriter, r *http.Request) {
-	paths := []string{
-		"project-id",
-		"numeric-project-id",
-	}
-	writeText(w, r, strings.Join(paths, "\n"))
+func projectNode() *tree.Node {
+	return tree.Directory(
+		tree.Child("attributes", projectAttributesNode()),
+		tree.Child("numeric-project-id", tree.Value(projectNumericId)),
+		tree.Child("project-id", tree.Value(projectId)),
+	)
 }
 
-func projectId(w http.ResponseWriter, r *http.Request) {
-	writeText(w, r, config.Current.ProjectId)
+// projectAttributesNode serves the project-wide metadata keys, kept in a ConfigMap in the lcm namespace.
+func projectAttributesNode() *tree.Node {
+	attributes := tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		configMap, err := kubernetes.GetLcmConfigMap(config.Current.ProjectAttributes)
+		if errorv1.IsNotFound(err) {
+			return []tree.Entry{}, nil
+		} else if err != nil {
+			slog.Error("failed to get project attributes", "configmap", config.Current.ProjectAttributes, "err", err)
+			return nil, err
+		}
+
+		keys := lo.Keys(configMap.Data)
+		sort.Strings(keys)
+		return lo.Map(keys, func(key string, i int) tree.Entry {
+			return tree.Child(key, tree.StaticValue(configMap.Data[key]))
+		}), nil
+	})
+	attributes.RawKeys = true
+	return attributes
 }
 
-func projectNumericId(w http.ResponseWriter, r *http.Request) {
-	project := googleclient.GetProject(config.Current.ProjectId)
-	if project == nil {
-		http.Error(w, "failed to get project", http.StatusInternalServerError)
-		return
+func projectId(r *http.Request) (any, error) {
+	return config.Current.ProjectId, nil
+}
+
+func projectNumericId(r *http.Request) (any, error) {
+	number, err := projectNumber()
+	if err != nil {
+		return nil, err
 	}
 
-	numericId := strings.TrimPrefix(project.Name, "projects/")
+	numericId, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return numericId, nil
+}
 
-	writeText(w, r, numericId)
+func projectNumber() (string, error) {
+	project := googleclient.GetProject(config.Current.ProjectId)
+	if project == nil {
+		return "", tree.NewError(http.StatusInternalServerError, "failed to get project")
+	}
+	return strings.TrimPrefix(project.Name, "projects/"), nil
 }
@@ -0,0 +1,223 @@
+package google
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"golang.org/x/exp/slog"
+)
+
+// jsonArray is a list served as a json array, even when text is requested.
+type jsonArray []string
+
+// metadataHandler serves the tree below the mount point of the route,
+// honoring the recursive and alt query parameters like the real metadata server.
+func metadataHandler(root *tree.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := chi.URLParam(r, "*")
+		slashed := path == "" || strings.HasSuffix(path, "/")
+
+		query := r.URL.Query()
+		alt := query.Get("alt")
+		if alt != "" && alt != "json" && alt != "text" {
+			http.Error(w, "invalid alt parameter", http.StatusBadRequest)
+			return
+		}
+
+		node, err := root.Lookup(r, tree.Segments(path))
+		if err != nil {
+			writeMetadataError(w, err)
+			return
+		}
+
+		if !node.IsDirectory() {
+			if slashed {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
+			val, err := node.Value(r)
+			if err != nil {
+				writeMetadataError(w, err)
+				return
+			}
+			if text, ok := textValue(val); ok && alt != "json" {
+				writeText(w, r, text)
+			} else {
+				render.JSON(w, r, val)
+			}
+			return
+		}
+
+		// Directories are always addressed with a trailing slash
+		if !slashed {
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			return
+		}
+
+		if query.Get("recursive") == "true" {
+			if alt == "text" {
+				lines := []string{}
+				if err := recursiveText(r, node, "", &lines); err != nil {
+					writeMetadataError(w, err)
+					return
+				}
+				writeText(w, r, strings.Join(lines, "\n")+"\n")
+				return
+			}
+			val, err := recursiveJson(r, node)
+			if err != nil {
+				writeMetadataError(w, err)
+				return
+			}
+			render.JSON(w, r, val)
+			return
+		}
+
+		names, err := node.Listing(r)
+		if err != nil {
+			writeMetadataError(w, err)
+			return
+		}
+		if alt == "json" {
+			render.JSON(w, r, names)
+			return
+		}
+		// Trailing newline matches GCP behavior
+		writeText(w, r, strings.Join(names, "\n")+"\n")
+	}
+}
+
+// recursiveJson resolves the node and everything below it into json-encodable values.
+// Children that are not found for the calling pod are left out.
+func recursiveJson(r *http.Request, n *tree.Node) (any, error) {
+	if !n.IsDirectory() {
+		return n.Value(r)
+	}
+
+	entries, err := n.Children(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.List {
+		values := []any{}
+		for _, e := range entries {
+			val, err := recursiveJson(r, e.Node)
+			if tree.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+		}
+		return values, nil
+	}
+
+	values := map[string]any{}
+	for _, e := range entries {
+		if e.Node.Hidden {
+			continue
+		}
+		val, err := recursiveJson(r, e.Node)
+		if tree.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		key := e.Name
+		if !n.RawKeys {
+			key = camelCase(key)
+		}
+		values[key] = val
+	}
+	return values, nil
+}
+
+// recursiveText flattens the node into "path value" lines, the format of alt=text on recursive requests.
+func recursiveText(r *http.Request, n *tree.Node, prefix string, lines *[]string) error {
+	if !n.IsDirectory() {
+		val, err := n.Value(r)
+		if err != nil {
+			return err
+		}
+		if array, ok := val.(jsonArray); ok {
+			val = []string(array)
+		}
+		if list, ok := val.([]string); ok {
+			for i, item := range list {
+				*lines = append(*lines, fmt.Sprintf("%s/%d %s", prefix, i, item))
+			}
+			return nil
+		}
+		text, ok := textValue(val)
+		if !ok {
+			return nil
+		}
+		*lines = append(*lines, fmt.Sprintf("%s %s", prefix, text))
+		return nil
+	}
+
+	entries, err := n.Children(r)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Node.Hidden {
+			continue
+		}
+		path := e.Name
+		if prefix != "" {
+			path = prefix + "/" + e.Name
+		}
+		err := recursiveText(r, e.Node, path, lines)
+		if tree.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textValue formats plain values as text, anything structured is left to json.
+func textValue(val any) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case []string:
+		return strings.Join(v, "\n"), true
+	case int, int64, uint64, bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
+
+func camelCase(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func writeMetadataError(w http.ResponseWriter, err error) {
+	var metadataErr *tree.Error
+	if errors.As(err, &metadataErr) {
+		http.Error(w, metadataErr.Message, metadataErr.Status)
+		return
+	}
+	slog.Error("failed to resolve metadata", "err", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
@@ -0,0 +1,140 @@
+package google
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/magnm/lcm/pkg/routes/tree"
+)
+
+func renderTree() *tree.Node {
+	attributes := tree.Directory(
+		tree.Child("cluster-name", tree.StaticValue("dev")),
+		tree.Child("enable-oslogin", tree.StaticValue("TRUE")),
+	)
+	attributes.RawKeys = true
+
+	interfaces := tree.Directory(
+		tree.Child("0", tree.Directory(tree.Child("ip", tree.StaticValue("10.0.0.1")))),
+		tree.Child("1", tree.Value(func(r *http.Request) (any, error) {
+			return nil, tree.NotFound()
+		})),
+	)
+	interfaces.List = true
+
+	return tree.Directory(
+		tree.Child("attributes", attributes),
+		tree.Child("id", tree.StaticValue(uint64(42))),
+		tree.Child("machine-type", tree.StaticValue("e2-standard-4")),
+		tree.Child("missing", tree.Value(func(r *http.Request) (any, error) {
+			return nil, tree.NotFound()
+		})),
+		tree.Child("network-interfaces", interfaces),
+		tree.Child("scopes", tree.StaticValue([]string{"a", "b"})),
+		tree.Child("tags", tree.StaticValue(jsonArray{"web"})),
+		tree.Child("token", tree.Hidden(tree.StaticValue("secret"))),
+		tree.Child("config", tree.StaticValue(map[string]any{"key": "value"})),
+	)
+}
+
+func TestRecursiveJson(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	got, err := recursiveJson(r, renderTree())
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+
+	want := map[string]any{
+		"attributes": map[string]any{
+			"cluster-name":   "dev",
+			"enable-oslogin": "TRUE",
+		},
+		"id":          uint64(42),
+		"machineType": "e2-standard-4",
+		"networkInterfaces": []any{
+			map[string]any{"ip": "10.0.0.1"},
+		},
+		"scopes": []string{"a", "b"},
+		"tags":   jsonArray{"web"},
+		"config": map[string]any{"key": "value"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recursiveJson = %#v, want %#v", got, want)
+	}
+}
+
+func TestRecursiveJsonError(t *testing.T) {
+	failing := tree.Directory(tree.Child("value", tree.Value(func(r *http.Request) (any, error) {
+		return nil, tree.NewError(http.StatusInternalServerError, "failed")
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := recursiveJson(r, failing); err == nil || tree.IsNotFound(err) {
+		t.Errorf("err = %v, want the error of the value", err)
+	}
+}
+
+func TestRecursiveText(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name: "root",
+			want: []string{
+				"attributes/cluster-name dev",
+				"attributes/enable-oslogin TRUE",
+				"id 42",
+				"machine-type e2-standard-4",
+				"network-interfaces/0/ip 10.0.0.1",
+				"scopes/0 a",
+				"scopes/1 b",
+				"tags/0 web",
+			},
+		},
+		{
+			name:   "below a prefix",
+			prefix: "instance",
+			want: []string{
+				"instance/attributes/cluster-name dev",
+				"instance/attributes/enable-oslogin TRUE",
+				"instance/id 42",
+				"instance/machine-type e2-standard-4",
+				"instance/network-interfaces/0/ip 10.0.0.1",
+				"instance/scopes/0 a",
+				"instance/scopes/1 b",
+				"instance/tags/0 web",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			lines := []string{}
+			if err := recursiveText(r, renderTree(), tt.prefix, &lines); err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(lines, tt.want) {
+				t.Errorf("recursiveText = %q, want %q", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	tests := map[string]string{
+		"id":                 "id",
+		"machine-type":       "machineType",
+		"network-interfaces": "networkInterfaces",
+		"trailing-":          "trailing",
+	}
+	for name, want := range tests {
+		if got := camelCase(name); got != want {
+			t.Errorf("camelCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -1,32 +1,77 @@
 package google
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-chi/chi"
-	"github.com/go-chi/render"
 	"github.com/magnm/lcm/config"
 	googleclient "github.com/magnm/lcm/pkg/cloud/client/google"
 	"github.com/magnm/lcm/pkg/kubernetes"
 	kubegoogle "github.com/magnm/lcm/pkg/kubernetes/google"
+	"github.com/magnm/lcm/pkg/providers"
+	"github.com/magnm/lcm/pkg/routes/tree"
 	"github.com/samber/lo"
 	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// tokenCacheKey identifies tokens that are interchangeable:
+// same account, same normalized set of scopes and same delegation chain.
+type tokenCacheKey struct {
+	Email     string
+	Scopes    string
+	Delegates string
+}
+
 type cachedServiceAccountToken struct {
 	Token     string
 	ExpiresAt int64
 }
 
-var podServiceAccountCache = map[string]string{}
-var serviceAccountTokenCache = map[string]cachedServiceAccountToken{}
+// cachedPodServiceAccount remembers the resolved account along with the KSA version
+// it was resolved from, so that changes to the KSA binding are picked up.
+type cachedPodServiceAccount struct {
+	Emails     []string
+	KsaVersion string
+}
+
+// identityCacheKey identifies identity tokens with the same claims.
+// The instance is only set for the full format, which embeds it.
+type identityCacheKey struct {
+	Email           string
+	Audience        string
+	Format          string
+	IncludeLicenses bool
+	InstanceId      string
+	InstanceName    string
+	Zone            string
+}
+
+type cachedIdentityToken struct {
+	Token     string
+	ExpiresAt int64
+}
+
+var identityTokenCache = map[identityCacheKey]cachedIdentityToken{}
+var identityTokenLock sync.Mutex
+
+// Identity tokens are reused until shortly before they expire
+var identityTokenExpiryMargin = 5 * time.Minute
+
+var podServiceAccountCache = map[string]cachedPodServiceAccount{}
+var podServiceAccountLock sync.Mutex
+var serviceAccountTokenCache = map[tokenCacheKey]cachedServiceAccountToken{}
+var serviceAccountTokenLock sync.Mutex
 
-type recursiveServiceAccountResponse struct {
-	Aliases []string `json:"aliases"`
-	Email   string   `json:"email"`
-	Scopes  []string `json:"scopes"`
+func init() {
+	kubernetes.OnPodDeleted(forgetPodServiceAccounts)
 }
 
 type tokenResponse struct {
@@ -35,200 +80,275 @@ type tokenResponse struct {
 	TokenType   string `json:"token_type"`
 }
 
-func serviceAccounts(w http.ResponseWriter, r *http.Request) {
-	accountEmail := serviceAccountForPod(w, r)
-	if accountEmail == "" {
-		slog.Error("no service account found for pod")
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-
-	if r.URL.Query().Get("recursive") == "true" {
-		serviceAccountsRecursive(w, r)
-		return
-	}
+// serviceAccountsNode lists the accounts the calling pod may use.
+// The primary account is also served as default.
+func serviceAccountsNode() *tree.Node {
+	accounts := tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		accountEmails := serviceAccountsForPod(r)
+		if len(accountEmails) == 0 {
+			slog.Error("no service account found for pod")
+			return nil, tree.NotFound()
+		}
 
-	accounts := []string{
-		"default",
-		accountEmail,
-	}
-	accountFolders := lo.Map(accounts, func(acc string, i int) string {
-		return acc + "/"
+		entries := []tree.Entry{
+			tree.Child("default", serviceAccountNode(accountEmails[0], []string{"default"})),
+			tree.Child(accountEmails[0], serviceAccountNode(accountEmails[0], []string{"default"})),
+		}
+		for _, accountEmail := range accountEmails[1:] {
+			entries = append(entries, tree.Child(accountEmail, serviceAccountNode(accountEmail, []string{})))
+		}
+		return entries, nil
 	})
-	writeText(w, r, strings.Join(accountFolders, "\n"))
+	accounts.RawKeys = true
+	return accounts
 }
 
-func serviceAccountsRecursive(w http.ResponseWriter, r *http.Request) {
-	response := map[string]recursiveServiceAccountResponse{
-		"default": {
-			Aliases: []string{"default"},
-			Email:   serviceAccountForPod(w, r),
-			Scopes:  googleclient.TokenScopes,
-		},
-		serviceAccountForPod(w, r): {
-			Aliases: []string{"default"},
-			Email:   serviceAccountForPod(w, r),
-			Scopes:  googleclient.TokenScopes,
-		},
-	}
-	render.JSON(w, r, response)
+func serviceAccountNode(accountEmail string, aliases []string) *tree.Node {
+	return tree.Directory(
+		tree.Child("aliases", tree.StaticValue(aliases)),
+		tree.Child("email", tree.StaticValue(accountEmail)),
+		tree.Child("identity", tree.Hidden(tree.Value(func(r *http.Request) (any, error) {
+			return serviceAccountIdentity(r, accountEmail)
+		}))),
+		tree.Child("scopes", tree.Value(func(r *http.Request) (any, error) {
+			return normalizeScopes(r.URL.Query().Get("scopes")), nil
+		})),
+		tree.Child("token", tree.Hidden(tree.Value(func(r *http.Request) (any, error) {
+			return serviceAccountToken(r, accountEmail)
+		}))),
+	)
 }
 
-func serviceAccount(w http.ResponseWriter, r *http.Request) {
-	accountEmail := serviceAccountForPod(w, r)
-	if accountEmail == "" {
-		slog.Error("no service account found for pod")
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
+func serviceAccountIdentity(r *http.Request, accountEmail string) (any, error) {
+	audience := r.URL.Query().Get("audience")
+	if audience == "" {
+		return nil, tree.NewError(http.StatusBadRequest, "non-empty audience parameter required")
 	}
 
-	if r.URL.Query().Get("recursive") == "true" {
-		serviceAccountRecursive(w, r)
-		return
+	query := r.URL.Query()
+	key := identityCacheKey{
+		Email:           accountEmail,
+		Audience:        audience,
+		Format:          query.Get("format"),
+		IncludeLicenses: strings.EqualFold(query.Get("licenses"), "TRUE"),
+	}
+	if key.Format == "" {
+		key.Format = "standard"
+	}
+	if key.Format != "standard" && key.Format != "full" {
+		return nil, tree.NewError(http.StatusBadRequest, "format must be standard or full")
+	}
+	// Licenses and the instance are only part of the full format
+	if key.Format != "full" {
+		key.IncludeLicenses = false
+	} else {
+		id, err := instanceId(r)
+		if err != nil {
+			return nil, err
+		}
+		key.InstanceId = fmt.Sprint(id)
+		key.InstanceName = nameOfInstance(r)
+		key.Zone = zoneName(r)
 	}
 
-	paths := []string{
-		"aliases",
-		"email",
-		"identity",
-		"scopes",
-		"token",
-		"", // Empty line at the end matches GCP behavior
+	identityTokenLock.Lock()
+	cached, ok := identityTokenCache[key]
+	identityTokenLock.Unlock()
+	if ok && cached.ExpiresAt > time.Now().UTC().Add(identityTokenExpiryMargin).Unix() {
+		return cached.Token, nil
+	}
+
+	var token string
+	if signer := getIdentitySigner(); signer != nil {
+		signed, err := signIdentityToken(r, signer, key)
+		if err != nil {
+			slog.Error("failed to sign identity token", "email", accountEmail, "err", err)
+			return nil, tree.NewError(http.StatusInternalServerError, "failed to get identity token")
+		}
+		token = signed
+	} else {
+		// The IAM credentials api only issues standard tokens
+		if key.Format == "full" {
+			slog.Debug("format=full is not supported by the IAM credentials api, issuing a standard token", "email", accountEmail)
+		}
+		token = googleclient.GetServiceAccountIdentityToken(accountEmail, audience)
+	}
+	if token == "" {
+		return nil, tree.NewError(http.StatusInternalServerError, "failed to get identity token")
 	}
-	writeText(w, r, strings.Join(paths, "\n"))
-}
 
-func serviceAccountRecursive(w http.ResponseWriter, r *http.Request) {
-	response := recursiveServiceAccountResponse{
-		Aliases: []string{"default"},
-		Email:   serviceAccountForPod(w, r),
-		Scopes:  googleclient.TokenScopes,
+	if expiresAt, err := jwtExpiry(token); err == nil {
+		identityTokenLock.Lock()
+		// Audiences are chosen by the caller, so expired tokens are dropped to keep the cache bounded
+		now := time.Now().UTC().Unix()
+		for cachedKey, cached := range identityTokenCache {
+			if cached.ExpiresAt <= now {
+				delete(identityTokenCache, cachedKey)
+			}
+		}
+		identityTokenCache[key] = cachedIdentityToken{
+			Token:     token,
+			ExpiresAt: expiresAt,
+		}
+		identityTokenLock.Unlock()
+	} else {
+		slog.Warn("not caching identity token without expiry", "email", accountEmail, "err", err)
 	}
-	render.JSON(w, r, response)
+
+	return token, nil
 }
 
-func serviceAccountAttr(w http.ResponseWriter, r *http.Request) {
-	email := chi.URLParam(r, "acc")
+// jwtExpiry reads the exp claim of a jwt, without verifying it.
+func jwtExpiry(token string) (int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errors.New("malformed jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return 0, err
+	}
+	if claims.Exp == 0 {
+		return 0, errors.New("jwt has no exp claim")
+	}
+	return claims.Exp, nil
+}
 
-	// Ensure the email requested is the one currently bound to the pod
-	accountEmail := serviceAccountForPod(w, r)
-	// If the email is "default", use the account bound to the pod
-	if email == "default" {
-		email = accountEmail
+func serviceAccountToken(r *http.Request, accountEmail string) (any, error) {
+	scopes := normalizeScopes(r.URL.Query().Get("scopes"))
+	delegates := delegatesForPod(r)
+	key := tokenCacheKey{
+		Email:     accountEmail,
+		Scopes:    strings.Join(scopes, ","),
+		Delegates: strings.Join(delegates, ","),
 	}
 
-	if accountEmail == "" || accountEmail != email {
-		slog.Error("invalid service account requested", "requested", email, "bound", accountEmail)
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
+	serviceAccountTokenLock.Lock()
+	cached, ok := serviceAccountTokenCache[key]
+	serviceAccountTokenLock.Unlock()
+	// Only return cached token if it expires in more than 15 minutes
+	if ok && cached.ExpiresAt > time.Now().UTC().Add(15*time.Minute).Unix() {
+		return tokenResponse{
+			AccessToken: cached.Token,
+			ExpiresIn:   int(cached.ExpiresAt - time.Now().UTC().Unix()),
+			TokenType:   "Bearer",
+		}, nil
 	}
 
-	key := chi.URLParam(r, "key")
-	slog.Debug("service account attr requested", "email", accountEmail, "key", key)
+	token := googleclient.GetServiceAccountToken(accountEmail, scopes, delegates)
+	if token == nil {
+		return nil, tree.NewError(http.StatusInternalServerError, "failed to get access token")
+	}
 
-	switch key {
-	case "aliases":
-		writeText(w, r, "default")
-	case "email":
-		writeText(w, r, accountEmail)
-	case "identity":
-		audience := r.URL.Query().Get("audience")
-		if audience == "" {
-			http.Error(w, "non-empty audience parameter required", http.StatusBadRequest)
-			return
-		}
-		token := googleclient.GetServiceAccountIdentityToken(accountEmail, audience)
-		if token == "" {
-			http.Error(w, "failed to get identity token", http.StatusInternalServerError)
-			return
-		}
-		writeText(w, r, token)
-	case "scopes":
-		writeText(w, r, strings.Join(googleclient.TokenScopes, ","))
-	case "token":
-		if cached, ok := serviceAccountTokenCache[accountEmail]; ok {
-			// Only return cached token if it expires in more than 15 minutes
-			if cached.ExpiresAt > time.Now().UTC().Add(15*time.Minute).Unix() {
-				render.JSON(w, r, tokenResponse{
-					AccessToken: cached.Token,
-					ExpiresIn:   int(cached.ExpiresAt - time.Now().UTC().Unix()),
-					TokenType:   "Bearer",
-				})
-				return
-			}
+	serviceAccountTokenLock.Lock()
+	// Scopes are chosen by the caller, so expired tokens are dropped to keep the cache bounded
+	now := time.Now().UTC().Unix()
+	for cachedKey, cached := range serviceAccountTokenCache {
+		if cached.ExpiresAt <= now {
+			delete(serviceAccountTokenCache, cachedKey)
 		}
+	}
+	serviceAccountTokenCache[key] = cachedServiceAccountToken{
+		Token:     token.AccessToken,
+		ExpiresAt: token.ExpiresAt.Unix(),
+	}
+	serviceAccountTokenLock.Unlock()
 
-		customScopes := []string{}
-		if scopes := r.URL.Query().Get("scopes"); scopes != "" {
-			customScopes = strings.Split(scopes, ",")
-		}
+	return tokenResponse{
+		AccessToken: token.AccessToken,
+		ExpiresIn:   int(token.ExpiresAt.Sub(time.Now().UTC()).Seconds()),
+		TokenType:   "Bearer",
+	}, nil
+}
 
-		token := googleclient.GetServiceAccountToken(accountEmail, customScopes)
-		if token == nil {
-			http.Error(w, "failed to get access token", http.StatusInternalServerError)
-			return
+// normalizeScopes turns the scopes query parameter into a sorted set,
+// falling back to the default scopes. These are the scopes a token is issued with,
+// so the scopes entry reports the scopes of the token served for the same request.
+func normalizeScopes(param string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(param, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
 		}
-		serviceAccountTokenCache[accountEmail] = cachedServiceAccountToken{
-			Token:     token.AccessToken,
-			ExpiresAt: token.ExpiresAt.Unix(),
-		}
-		render.JSON(w, r, tokenResponse{
-			AccessToken: token.AccessToken,
-			ExpiresIn:   int(token.ExpiresAt.Sub(time.Now().UTC()).Seconds()),
-			TokenType:   "Bearer",
-		})
 	}
+	if len(scopes) == 0 {
+		scopes = append(scopes, googleclient.TokenScopes...)
+	}
+
+	scopes = lo.Uniq(scopes)
+	sort.Strings(scopes)
+	return scopes
 }
 
-func serviceAccountForPod(w http.ResponseWriter, r *http.Request) string {
+// serviceAccountsForPod returns the accounts the calling pod may use, the primary one first.
+func serviceAccountsForPod(r *http.Request) []string {
 	pod, err := kubernetes.CallingPod(r)
 	if err != nil {
 		slog.Error("failed to get calling pod", "err", err)
-		return ""
+		return []string{}
 	}
 
-	// If the pod is using the default kubernetes service account,
-	// and LCM has a default cloud service account configured,
-	// we should just always return the default.
-	if (pod.Spec.ServiceAccountName == "" ||
-		pod.Spec.ServiceAccountName == "default") &&
-		config.Current.DefaultAccount != "" {
-		return config.Current.DefaultAccount
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		slog.Error("failed to get service account for pod", "err", err)
+		return []string{}
 	}
 
-	if email, ok := podServiceAccountCache[pod.Name]; ok {
-		return email
+	cacheKey := podCacheKey(pod)
+	podServiceAccountLock.Lock()
+	cached, ok := podServiceAccountCache[cacheKey]
+	podServiceAccountLock.Unlock()
+	if ok && cached.KsaVersion == ksa.ResourceVersion {
+		return append([]string{}, cached.Emails...)
 	}
 
-	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	emails, err := providers.IdentitiesForPod(config.GoogleMetadata, pod)
 	if err != nil {
-		slog.Error("failed to get service account for pod", "err", err)
-		return ""
+		slog.Error("failed to resolve service accounts of pod", "err", err)
+		return []string{}
+	}
+	if len(emails) == 0 {
+		return emails
 	}
 
-	var email string
+	podServiceAccountLock.Lock()
+	podServiceAccountCache[cacheKey] = cachedPodServiceAccount{
+		Emails:     append([]string{}, emails...),
+		KsaVersion: ksa.ResourceVersion,
+	}
+	podServiceAccountLock.Unlock()
 
-	switch config.Current.KsaResolver {
-	case config.KsaBindingResolverAnnotation:
-		slog.Debug("using annotation to resolve ksa binding", "ksa", ksa)
+	return emails
+}
 
-		email = kubegoogle.GetGsaForKsa(ksa)
-		if email == "" {
-			slog.Error("no google service account binding found for ksa", "ksa", ksa)
-		}
-	case config.KsaBindingResolverCRD:
-		slog.Error("using CRD to resolve ksa binding is not implemented", "ksa", ksa)
+// delegatesForPod returns the delegation chain declared on the ksa of the calling pod.
+// The default account is minted directly.
+func delegatesForPod(r *http.Request) []string {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil || kubegoogle.UsesDefaultAccount(pod) {
+		return []string{}
 	}
-
-	// Verify that this service account is permitted to be used
-	if !googleclient.IsServiceAccountPermitted(email) {
-		slog.Error("service account is not permitted", "ksa", ksa, "gsa", email)
-		return ""
+	ksa, err := kubernetes.ServiceAccountForPod(pod)
+	if err != nil {
+		slog.Error("failed to get service account for pod", "err", err)
+		return []string{}
 	}
+	return kubegoogle.GetDelegatesForKsa(ksa)
+}
 
-	if email != "" {
-		podServiceAccountCache[pod.Name] = email
-	}
+func podCacheKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
 
-	return email
+// forgetPodServiceAccounts drops the accounts resolved for a deleted pod.
+func forgetPodServiceAccounts(pod *corev1.Pod) {
+	podServiceAccountLock.Lock()
+	defer podServiceAccountLock.Unlock()
+	delete(podServiceAccountCache, podCacheKey(pod))
 }
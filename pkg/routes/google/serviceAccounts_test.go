@@ -0,0 +1,73 @@
+package google
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	googleclient "github.com/magnm/lcm/pkg/cloud/client/google"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		param string
+		want  []string
+	}{
+		{name: "default", param: "", want: googleclient.TokenScopes},
+		{name: "only separators", param: " , ,", want: googleclient.TokenScopes},
+		{name: "single", param: "drive.readonly", want: []string{"drive.readonly"}},
+		{name: "sorted", param: "userinfo.email,cloud-platform", want: []string{"cloud-platform", "userinfo.email"}},
+		{name: "trimmed", param: " cloud-platform , drive ", want: []string{"cloud-platform", "drive"}},
+		{name: "duplicates", param: "drive,cloud-platform,drive", want: []string{"cloud-platform", "drive"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeScopes(tt.param); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeScopes(%q) = %v, want %v", tt.param, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeScopesCopiesDefault(t *testing.T) {
+	scopes := normalizeScopes("")
+	scopes[0] = "changed"
+	if googleclient.TokenScopes[0] == "changed" {
+		t.Error("normalizeScopes returned the default scopes themselves")
+	}
+}
+
+func jwtWithPayload(payload string) string {
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+}
+
+func TestJwtExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		want    int64
+		wantErr bool
+	}{
+		{name: "exp claim", token: jwtWithPayload(`{"exp":1700000000,"aud":"a"}`), want: 1700000000},
+		{name: "no exp claim", token: jwtWithPayload(`{"aud":"a"}`), wantErr: true},
+		{name: "payload not json", token: jwtWithPayload(`exp`), wantErr: true},
+		{name: "payload not base64", token: "header.!!!.signature", wantErr: true},
+		{name: "too few parts", token: "header.payload", wantErr: true},
+		{name: "too many parts", token: jwtWithPayload(`{"exp":1}`) + ".extra", wantErr: true},
+		{name: "empty", token: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jwtExpiry(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("jwtExpiry = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package google
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"golang.org/x/exp/slog"
+)
+
+// bufferedResponse holds a handler's response, so that it can be hashed
+// and compared before anything is sent to the client.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes()) //nolint:errcheck
+}
+
+// serveBuffered runs the handler and tags successful responses with an ETag
+// derived from the content, the same way the real metadata server does.
+func serveBuffered(next http.Handler, r *http.Request) *bufferedResponse {
+	response := &bufferedResponse{header: http.Header{}}
+	next.ServeHTTP(response, r)
+
+	if response.status == 0 || response.status == http.StatusOK {
+		sum := sha256.Sum256(response.body.Bytes())
+		response.header.Set("ETag", hex.EncodeToString(sum[:8]))
+	}
+	return response
+}
+
+// changeTopics are the resources the metadata below the path of the request derives from,
+// so that waiting requests are only re-evaluated when one of those changes.
+func changeTopics(r *http.Request) []string {
+	path := strings.TrimPrefix(r.URL.Path, "/computeMetadata/")
+	for _, version := range metadataVersions {
+		path = strings.TrimPrefix(path, version+"/")
+	}
+
+	topics := []string{}
+	if path == "" || strings.HasPrefix(path, "project") {
+		topics = append(topics, kubernetes.ChangeTopic("ConfigMap", config.Current.LcmNamespace, config.Current.ProjectAttributes))
+	}
+	if path == "" || strings.HasPrefix(path, "instance") {
+		if pod, err := kubernetes.LookupCallingPod(r); err == nil {
+			topics = append(topics, kubernetes.PodChangeTopics(pod)...)
+		}
+		if config.Current.NodeName != "" {
+			topics = append(topics, kubernetes.ChangeTopic("Node", "", config.Current.NodeName))
+		}
+	}
+	return topics
+}
+
+// waitForChange implements the wait_for_change, last_etag and timeout_sec query parameters.
+// The request is blocked until the response differs from last_etag (or from the
+// current value if no etag is given), re-evaluating it whenever a watched resource changes.
+func waitForChange(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("wait_for_change") != "true" {
+			serveBuffered(next, r).writeTo(w)
+			return
+		}
+
+		timeout := config.Current.MaxWaitForChange
+		if timeoutSec := query.Get("timeout_sec"); timeoutSec != "" {
+			seconds, err := strconv.Atoi(timeoutSec)
+			if err != nil || seconds <= 0 {
+				http.Error(w, "invalid timeout_sec parameter", http.StatusBadRequest)
+				return
+			}
+			if requested := time.Duration(seconds) * time.Second; requested < timeout {
+				timeout = requested
+			}
+		}
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+
+		lastEtag := query.Get("last_etag")
+		stopWaiting := func() {}
+		defer func() { stopWaiting() }()
+		for {
+			var changed <-chan struct{}
+			stopWaiting()
+			changed, stopWaiting = kubernetes.Changes.Wait(changeTopics(r)...)
+			rotated := workloadCertificateRotations()
+
+			response := serveBuffered(next, r)
+			etag := response.header.Get("ETag")
+			if etag == "" || (lastEtag != "" && etag != lastEtag) {
+				response.writeTo(w)
+				return
+			}
+			if lastEtag == "" {
+				lastEtag = etag
+			}
+
+			select {
+			case <-changed:
+				slog.Debug("re-evaluating metadata after change", "path", r.URL.Path)
+			case <-rotated:
+				slog.Debug("re-evaluating metadata after certificate rotation", "path", r.URL.Path)
+			case <-deadline.C:
+				response.writeTo(w)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
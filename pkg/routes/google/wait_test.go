@@ -0,0 +1,117 @@
+package google
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+)
+
+// changingHandler serves its current body, which a test may change while a request waits.
+type changingHandler struct {
+	mu   sync.Mutex
+	body string
+}
+
+func (h *changingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Write([]byte(h.body)) //nolint:errcheck
+}
+
+func (h *changingHandler) set(body string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.body = body
+}
+
+func etagOf(t *testing.T, handler http.Handler, path string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Header().Get("ETag")
+}
+
+func TestWaitForChange(t *testing.T) {
+	config.Current.MaxWaitForChange = time.Minute
+	config.Current.LcmNamespace = "lcm"
+	config.Current.ProjectAttributes = "project-attributes"
+	path := "/computeMetadata/v1/project/attributes/key"
+	topic := kubernetes.ChangeTopic("ConfigMap", "lcm", "project-attributes")
+
+	tests := []struct {
+		name     string
+		query    string
+		lastEtag bool
+		change   bool
+		status   int
+		body     string
+		minWait  time.Duration
+	}{
+		{name: "without waiting", query: "", status: http.StatusOK, body: "old"},
+		{name: "invalid timeout", query: "wait_for_change=true&timeout_sec=soon", status: http.StatusBadRequest},
+		{name: "negative timeout", query: "wait_for_change=true&timeout_sec=-1", status: http.StatusBadRequest},
+		{name: "outdated etag", query: "wait_for_change=true&last_etag=0000000000000000", status: http.StatusOK, body: "old"},
+		{name: "timeout without change", query: "wait_for_change=true&timeout_sec=1", status: http.StatusOK, body: "old", minWait: time.Second},
+		{name: "timeout with current etag", query: "wait_for_change=true&timeout_sec=1", lastEtag: true, status: http.StatusOK, body: "old", minWait: time.Second},
+		{name: "change", query: "wait_for_change=true&timeout_sec=10", change: true, status: http.StatusOK, body: "new"},
+		{name: "change with current etag", query: "wait_for_change=true&timeout_sec=10", lastEtag: true, change: true, status: http.StatusOK, body: "new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &changingHandler{body: "old"}
+			handler := waitForChange(next)
+
+			query := tt.query
+			if tt.lastEtag {
+				query += "&last_etag=" + etagOf(t, handler, path)
+			}
+			if tt.change {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					next.set("new")
+					kubernetes.Changes.Notify(topic)
+				}()
+			}
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?"+query, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if body := w.Body.String(); body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Error("response has no etag")
+			}
+			if waited := time.Since(start); waited < tt.minWait {
+				t.Errorf("returned after %s, want at least %s", waited, tt.minWait)
+			}
+		})
+	}
+}
+
+func TestWaitForChangeEtag(t *testing.T) {
+	handler := waitForChange(&changingHandler{body: "value"})
+	first := etagOf(t, handler, "/computeMetadata/v1/project/project-id")
+	second := etagOf(t, handler, "/computeMetadata/v1/project/project-id")
+	if first == "" || first != second {
+		t.Errorf("etags of the same content differ: %q and %q", first, second)
+	}
+
+	other := etagOf(t, waitForChange(&changingHandler{body: "other"}), "/computeMetadata/v1/project/project-id")
+	if other == first {
+		t.Errorf("etags of different content are both %q", first)
+	}
+}
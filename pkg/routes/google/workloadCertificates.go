@@ -0,0 +1,141 @@
+package google
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/certificates"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/magnm/lcm/pkg/routes/tree"
+	"golang.org/x/exp/slog"
+)
+
+type workloadCertificatesStatus struct {
+	Status string `json:"status"`
+}
+
+type workloadCertificatesConfigStatus struct {
+	Status                   string `json:"status"`
+	SpiffeTrustDomain        string `json:"spiffeTrustDomain"`
+	KeyAlgorithm             string `json:"keyAlgorithm"`
+	CertificateLifetime      string `json:"certificateLifetime"`
+	RotationWindowPercentage int    `json:"rotationWindowPercentage"`
+}
+
+type workloadCredential struct {
+	CertificatePem string `json:"certificatePem"`
+	PrivateKeyPem  string `json:"privateKeyPem"`
+}
+
+type workloadIdentitiesResponse struct {
+	workloadCertificatesStatus
+	WorkloadCredentials map[string]workloadCredential `json:"workloadCredentials"`
+}
+
+type trustAnchor struct {
+	TrustAnchorsPem string `json:"trustAnchorsPem"`
+}
+
+type trustAnchorsResponse struct {
+	workloadCertificatesStatus
+	TrustAnchors map[string]trustAnchor `json:"trustAnchors"`
+}
+
+var workloadIssuer *certificates.Issuer
+var workloadIssuerOnce sync.Once
+
+// workloadCertificatesNode serves mesh certificates for the identity of the calling pod,
+// issued by the local CA. The node is absent unless a CA is configured.
+func workloadCertificatesNode() *tree.Node {
+	certificatesNode := tree.Directory(
+		tree.Child("config-status", tree.Value(workloadCertificatesConfig)),
+		tree.Child("trust-anchors", tree.Value(workloadTrustAnchors)),
+		tree.Child("workload-identities", tree.Hidden(tree.Value(workloadIdentities))),
+	)
+	return tree.DynamicDirectory(func(r *http.Request) ([]tree.Entry, error) {
+		if getWorkloadIssuer() == nil {
+			return nil, tree.NotFound()
+		}
+		return certificatesNode.Children(r)
+	})
+}
+
+func workloadCertificatesConfig(r *http.Request) (any, error) {
+	issuer := getWorkloadIssuer()
+	return workloadCertificatesConfigStatus{
+		Status:                   "OK",
+		SpiffeTrustDomain:        trustDomain(),
+		KeyAlgorithm:             "ECDSA_P256",
+		CertificateLifetime:      fmt.Sprintf("%.0fs", issuer.Lifetime().Seconds()),
+		RotationWindowPercentage: 50,
+	}, nil
+}
+
+func workloadTrustAnchors(r *http.Request) (any, error) {
+	return trustAnchorsResponse{
+		workloadCertificatesStatus: workloadCertificatesStatus{Status: "OK"},
+		TrustAnchors: map[string]trustAnchor{
+			trustDomain(): {TrustAnchorsPem: getWorkloadIssuer().TrustAnchorsPem()},
+		},
+	}, nil
+}
+
+func workloadIdentities(r *http.Request) (any, error) {
+	pod, err := kubernetes.CallingPod(r)
+	if err != nil {
+		slog.Error("failed to get calling pod", "err", err)
+		return nil, tree.NotFound()
+	}
+
+	ksa := pod.Spec.ServiceAccountName
+	if ksa == "" {
+		ksa = "default"
+	}
+	spiffeId := fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain(), pod.Namespace, ksa)
+
+	certificate, err := getWorkloadIssuer().Issue(spiffeId)
+	if err != nil {
+		slog.Error("failed to issue workload certificate", "id", spiffeId, "err", err)
+		return nil, tree.NewError(http.StatusInternalServerError, "failed to issue workload certificate")
+	}
+
+	return workloadIdentitiesResponse{
+		workloadCertificatesStatus: workloadCertificatesStatus{Status: "OK"},
+		WorkloadCredentials: map[string]workloadCredential{
+			spiffeId: {
+				CertificatePem: certificate.CertificatePem,
+				PrivateKeyPem:  certificate.PrivateKeyPem,
+			},
+		},
+	}, nil
+}
+
+func trustDomain() string {
+	return config.Current.ProjectId + ".svc.id.goog"
+}
+
+func getWorkloadIssuer() *certificates.Issuer {
+	workloadIssuerOnce.Do(func() {
+		cfg := config.Current.Google
+		if cfg.WorkloadCaCert == "" || cfg.WorkloadCaKey == "" {
+			return
+		}
+		issuer, err := certificates.LoadIssuer(cfg.WorkloadCaCert, cfg.WorkloadCaKey, cfg.WorkloadCertLifetime)
+		if err != nil {
+			slog.Error("failed to load workload certificate CA", "err", err)
+			return
+		}
+		workloadIssuer = issuer
+	})
+	return workloadIssuer
+}
+
+// workloadCertificateRotations wakes up long-polling clients when their certificate is renewed.
+func workloadCertificateRotations() <-chan struct{} {
+	if issuer := getWorkloadIssuer(); issuer != nil {
+		return issuer.Rotations.Wait()
+	}
+	return nil
+}
@@ -5,7 +5,13 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/magnm/lcm/config"
-	"github.com/magnm/lcm/pkg/routes/google"
+	"github.com/magnm/lcm/pkg/providers"
+	// Providers register themselves when imported
+	_ "github.com/magnm/lcm/pkg/providers/aws"
+	_ "github.com/magnm/lcm/pkg/providers/azure"
+	_ "github.com/magnm/lcm/pkg/providers/google"
+	"github.com/magnm/lcm/pkg/routes/admin"
+	routesaws "github.com/magnm/lcm/pkg/routes/aws"
 	"github.com/magnm/lcm/pkg/routes/webhook"
 	"golang.org/x/exp/slog"
 )
@@ -14,14 +20,26 @@ func MainRouter(cfg config.Config) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Mount("/webhook", webhook.Routes())
+	if cfg.AdminEnabled {
+		// Every pod can reach lcm, so the admin routes must not be open to all of them
+		if cfg.AdminToken == "" {
+			slog.Error("ADMIN_TOKEN is required when the admin routes are enabled")
+			os.Exit(1)
+		}
+		r.Mount("/admin", admin.Routes(cfg.AdminToken))
+	}
 
-	switch cfg.Type {
-	case config.GoogleMetadata:
-		r.Mount("/", google.Routes())
-	default:
-		slog.Error("unknown metadata type", "type", cfg.Type)
+	enabled, err := providers.Enabled()
+	if err != nil {
+		slog.Error("unknown metadata type", "types", cfg.EnabledTypes(), "available", providers.Types(), "err", err)
 		os.Exit(1)
 	}
+	r.Mount("/", providers.Router(enabled))
 
 	return r
 }
+
+// ContainerCredentialsRouter serves the aws container credentials endpoint on its node-local address.
+func ContainerCredentialsRouter() *chi.Mux {
+	return routesaws.ContainerCredentialsRoutes()
+}
@@ -0,0 +1,143 @@
+package tree
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Node is an entry in a metadata tree, shared by the metadata servers of the clouds.
+// Leaves resolve a value and directories resolve their children, both per request,
+// since most values depend on the calling pod. How a node is rendered is up to each cloud.
+type Node struct {
+	value    func(r *http.Request) (any, error)
+	children func(r *http.Request) ([]Entry, error)
+	// Hidden nodes can be requested directly, but are left out of recursive responses
+	Hidden bool
+	// RawKeys keeps the names of the children in recursive responses, instead of converting them
+	RawKeys bool
+	// List directories are indexed by number, and rendered as arrays in recursive responses
+	List bool
+}
+
+type Entry struct {
+	Name string
+	Node *Node
+}
+
+// Error carries the status a metadata request fails with.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(status int, message string) error {
+	return &Error{Status: status, Message: message}
+}
+
+func NotFound() error {
+	return NewError(http.StatusNotFound, "Not Found")
+}
+
+func IsNotFound(err error) bool {
+	var metadataErr *Error
+	return errors.As(err, &metadataErr) && metadataErr.Status == http.StatusNotFound
+}
+
+func Child(name string, node *Node) Entry {
+	return Entry{Name: name, Node: node}
+}
+
+func Value(resolve func(r *http.Request) (any, error)) *Node {
+	return &Node{value: resolve}
+}
+
+func StaticValue(v any) *Node {
+	return Value(func(r *http.Request) (any, error) {
+		return v, nil
+	})
+}
+
+func Hidden(node *Node) *Node {
+	node.Hidden = true
+	return node
+}
+
+func Directory(entries ...Entry) *Node {
+	return DynamicDirectory(func(r *http.Request) ([]Entry, error) {
+		return entries, nil
+	})
+}
+
+func DynamicDirectory(children func(r *http.Request) ([]Entry, error)) *Node {
+	return &Node{children: children}
+}
+
+func (n *Node) IsDirectory() bool {
+	return n.children != nil
+}
+
+// Value resolves a leaf for the request.
+func (n *Node) Value(r *http.Request) (any, error) {
+	if n.IsDirectory() {
+		return nil, NotFound()
+	}
+	return n.value(r)
+}
+
+// Children resolves the entries of a directory for the request.
+func (n *Node) Children(r *http.Request) ([]Entry, error) {
+	if !n.IsDirectory() {
+		return nil, NotFound()
+	}
+	return n.children(r)
+}
+
+// Segments splits a path below the tree into the names to look up, ignoring empty segments.
+func Segments(path string) []string {
+	return strings.FieldsFunc(path, func(c rune) bool { return c == '/' })
+}
+
+// Lookup walks the names below the node, one segment at a time.
+func (n *Node) Lookup(r *http.Request, segments []string) (*Node, error) {
+	node := n
+	for _, name := range segments {
+		entries, err := node.Children(r)
+		if err != nil {
+			return nil, err
+		}
+		var next *Node
+		for _, e := range entries {
+			if e.Name == name {
+				next = e.Node
+				break
+			}
+		}
+		if next == nil {
+			return nil, NotFound()
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// Listing names the children of a directory, with a trailing slash on subdirectories.
+func (n *Node) Listing(r *http.Request) ([]string, error) {
+	entries, err := n.Children(r)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.Node.IsDirectory() {
+			names = append(names, e.Name+"/")
+		} else {
+			names = append(names, e.Name)
+		}
+	}
+	return names, nil
+}
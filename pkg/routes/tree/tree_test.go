@@ -0,0 +1,144 @@
+package tree
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func testTree() *Node {
+	return Directory(
+		Child("instance", Directory(
+			Child("hostname", StaticValue("host")),
+			Child("token", Hidden(StaticValue("secret"))),
+			Child("missing", Value(func(r *http.Request) (any, error) {
+				return nil, NotFound()
+			})),
+		)),
+		Child("project", DynamicDirectory(func(r *http.Request) ([]Entry, error) {
+			if r.URL.Query().Get("fail") != "" {
+				return nil, NewError(http.StatusInternalServerError, "failed")
+			}
+			return []Entry{Child("project-id", StaticValue("project"))}, nil
+		})),
+	)
+}
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "", want: []string{}},
+		{path: "/", want: []string{}},
+		{path: "instance/", want: []string{"instance"}},
+		{path: "instance//hostname", want: []string{"instance", "hostname"}},
+		{path: "/instance/hostname/", want: []string{"instance", "hostname"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := Segments(tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Segments(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		query     string
+		directory bool
+		value     any
+		status    int
+	}{
+		{name: "root", path: "", directory: true},
+		{name: "directory", path: "instance/", directory: true},
+		{name: "value", path: "instance/hostname", value: "host"},
+		{name: "hidden value", path: "instance/token", value: "secret"},
+		{name: "dynamic directory", path: "project/project-id", value: "project"},
+		{name: "unknown child", path: "instance/name", status: http.StatusNotFound},
+		{name: "below a value", path: "instance/hostname/name", status: http.StatusNotFound},
+		{name: "failing directory", path: "project/project-id", query: "fail=true", status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			node, err := testTree().Lookup(r, Segments(tt.path))
+			if tt.status != 0 {
+				var metadataErr *Error
+				if !errors.As(err, &metadataErr) || metadataErr.Status != tt.status {
+					t.Fatalf("err = %v, want status %d", err, tt.status)
+				}
+				if IsNotFound(err) != (tt.status == http.StatusNotFound) {
+					t.Errorf("IsNotFound = %v for status %d", IsNotFound(err), tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+
+			if node.IsDirectory() != tt.directory {
+				t.Fatalf("IsDirectory = %v, want %v", node.IsDirectory(), tt.directory)
+			}
+			if tt.directory {
+				if _, err := node.Value(r); !IsNotFound(err) {
+					t.Errorf("value of a directory: err = %v, want not found", err)
+				}
+				return
+			}
+			value, err := node.Value(r)
+			if err != nil || value != tt.value {
+				t.Errorf("value = %v, %v, want %v", value, err, tt.value)
+			}
+			if _, err := node.Children(r); !IsNotFound(err) {
+				t.Errorf("children of a value: err = %v, want not found", err)
+			}
+		})
+	}
+}
+
+func TestListing(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		want   []string
+		status int
+	}{
+		{name: "root", path: "", want: []string{"instance/", "project/"}},
+		{name: "hidden and missing values are listed", path: "instance", want: []string{"hostname", "token", "missing"}},
+		{name: "dynamic directory", path: "project", want: []string{"project-id"}},
+		{name: "value", path: "instance/hostname", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			node, err := testTree().Lookup(r, Segments(tt.path))
+			if err != nil {
+				t.Fatalf("lookup: %v", err)
+			}
+
+			names, err := node.Listing(r)
+			if tt.status != 0 {
+				var metadataErr *Error
+				if !errors.As(err, &metadataErr) || metadataErr.Status != tt.status {
+					t.Fatalf("err = %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Listing = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -24,8 +24,13 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	slog.Debug("admission review", "version", review.APIVersion)
+	oldPod, err := kubernetes.DecodeOldPod(review)
+	if err != nil {
+		http.Error(w, "failed to decode pod mutation request", http.StatusBadRequest)
+		return
+	}
 
-	patches, err := patchesForPod(pod, *review.Request.DryRun)
+	patches, err := patchesForPod(pod, oldPod, *review.Request.DryRun)
 	if err != nil {
 		slog.Error("failed to generate patches for pod", "err", err)
 		http.Error(w, "failed to generate patches for pod", http.StatusInternalServerError)
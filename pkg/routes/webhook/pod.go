@@ -2,46 +2,42 @@ package webhook
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/distribution/reference"
-	"github.com/magnm/lcm/config"
 	"github.com/magnm/lcm/pkg/kubernetes"
-	kubegoogle "github.com/magnm/lcm/pkg/kubernetes/google"
+	"github.com/magnm/lcm/pkg/providers"
 	"github.com/samber/lo"
 	"golang.org/x/exp/slog"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func patchesForPod(pod *corev1.Pod, dryRun bool) ([]kubernetes.PatchOperation, error) {
-	var (
-		patches []kubernetes.PatchOperation
-		err     error
-	)
+// patchesForPod mutates new pods, and updated pods as far as they lack anything. The old pod is nil on creation.
+func patchesForPod(pod *corev1.Pod, oldPod *corev1.Pod, dryRun bool) ([]kubernetes.PatchOperation, error) {
+	var err error
+	patches := protectOwnedSecret(pod, oldPod)
 
-	var dnsEntries []corev1.HostAlias
-	var envVars []corev1.EnvVar
-
-	switch config.Current.Type {
-	case config.GoogleMetadata:
-		dnsEntries = []corev1.HostAlias{
-			{IP: kubernetes.GetOurServiceIp(), Hostnames: []string{kubegoogle.MetadataServerDomain}},
-		}
+	enabled, err := providers.Enabled()
+	if err != nil {
+		return nil, err
+	}
 
-		envVars = []corev1.EnvVar{
-			{Name: "GCE_METADATA_IP", Value: kubernetes.GetOurServiceIp()},
-			{Name: "GCE_METADATA_HOST", Value: "metadata.google.internal"},
-		}
+	mutation, err := mutationForPod(enabled, pod, dryRun)
+	if err != nil {
+		return nil, err
 	}
+	dnsEntries := mutation.HostAliases
+	envVars := mutation.EnvVars
 
 	// Check if we should add imagePullSecret or envVars
 	for i, container := range pod.Spec.Containers {
-		patches, err = patchesForContainer(patches, envVars, "containers", pod, container, i, dryRun)
+		patches, err = patchesForContainer(patches, enabled, envVars, "containers", pod, container, i, dryRun)
 		if err != nil {
 			return nil, err
 		}
 	}
 	for i, initContainer := range pod.Spec.InitContainers {
-		patches, err = patchesForContainer(patches, envVars, "initContainers", pod, initContainer, i, dryRun)
+		patches, err = patchesForContainer(patches, enabled, envVars, "initContainers", pod, initContainer, i, dryRun)
 		if err != nil {
 			return nil, err
 		}
@@ -69,11 +65,103 @@ func patchesForPod(pod *corev1.Pod, dryRun bool) ([]kubernetes.PatchOperation, e
 		}
 	}
 
+	if len(mutation.Annotations) > 0 {
+		if len(pod.Annotations) == 0 {
+			patches = append(patches, kubernetes.PatchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations",
+				Value: mutation.Annotations,
+			})
+		} else {
+			for key, value := range mutation.Annotations {
+				if _, ok := pod.Annotations[key]; !ok {
+					patches = append(patches, kubernetes.PatchOperation{
+						Op:    "add",
+						Path:  "/metadata/annotations/" + jsonPointerEscaper.Replace(key),
+						Value: value,
+					})
+				}
+			}
+		}
+	}
+
 	return patches, nil
 }
 
+// mutationForPod combines what the enabled providers add to the pod.
+// Where providers add the same env var, the first one wins.
+func mutationForPod(enabled []providers.Provider, pod *corev1.Pod, dryRun bool) (*providers.PodMutation, error) {
+	combined := &providers.PodMutation{Annotations: map[string]string{}}
+	for _, provider := range enabled {
+		mutation, err := provider.MutatePod(pod, dryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, alias := range mutation.HostAliases {
+			index := lo.IndexOf(lo.Map(combined.HostAliases, func(existing corev1.HostAlias, i int) string {
+				return existing.IP
+			}), alias.IP)
+			if index < 0 {
+				combined.HostAliases = append(combined.HostAliases, alias)
+			} else {
+				combined.HostAliases[index].Hostnames = lo.Union(combined.HostAliases[index].Hostnames, alias.Hostnames)
+			}
+		}
+		for _, env := range mutation.EnvVars {
+			if !lo.ContainsBy(combined.EnvVars, func(existing corev1.EnvVar) bool {
+				return existing.Name == env.Name
+			}) {
+				combined.EnvVars = append(combined.EnvVars, env)
+			}
+		}
+		for key, value := range mutation.Annotations {
+			if _, ok := combined.Annotations[key]; !ok {
+				combined.Annotations[key] = value
+			}
+		}
+	}
+	return combined, nil
+}
+
+// protectOwnedSecret keeps pods from claiming a secret of their choice, which lcm would delete together with the pod.
+// The annotation is stripped from new pods, and changes to it are reverted on updates.
+// The pod is updated to match, so providers see the annotation as lcm set it.
+func protectOwnedSecret(pod *corev1.Pod, oldPod *corev1.Pod) []kubernetes.PatchOperation {
+	name, ok := pod.Annotations[kubernetes.OwnedSecretAnnotation]
+	oldName, oldOk := "", false
+	if oldPod != nil {
+		oldName, oldOk = oldPod.Annotations[kubernetes.OwnedSecretAnnotation]
+	}
+	if ok == oldOk && name == oldName {
+		return []kubernetes.PatchOperation{}
+	}
+
+	slog.Warn("reverting owned secret annotation set on pod", "namespace", pod.Namespace, "pod", pod.Name, "secret", name)
+	path := "/metadata/annotations/" + jsonPointerEscaper.Replace(kubernetes.OwnedSecretAnnotation)
+	if oldOk {
+		patch := kubernetes.PatchOperation{Op: "add", Path: path, Value: oldName}
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+			patch = kubernetes.PatchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations",
+				Value: map[string]string{kubernetes.OwnedSecretAnnotation: oldName},
+			}
+		}
+		pod.Annotations[kubernetes.OwnedSecretAnnotation] = oldName
+		return []kubernetes.PatchOperation{patch}
+	}
+	delete(pod.Annotations, kubernetes.OwnedSecretAnnotation)
+	return []kubernetes.PatchOperation{{Op: "remove", Path: path}}
+}
+
+// Escapes keys for use in json patch paths
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
 func patchesForContainer(
 	patches []kubernetes.PatchOperation,
+	enabled []providers.Provider,
 	envVars []corev1.EnvVar,
 	containerTypeJsonPath string,
 	pod *corev1.Pod,
@@ -91,20 +179,16 @@ func patchesForContainer(
 		return nil, err
 	}
 
-	var pullSecretRef *corev1.LocalObjectReference
-
-	switch config.Current.Type {
-	case config.GoogleMetadata:
-		if kubegoogle.ShouldAddImagePullSecret(image) {
-			pullSecretRef, err = kubegoogle.PullSecretForImage(image, pod.Namespace, dryRun)
-			if err != nil {
-				slog.Error("failed to create image pull secret", "err", err)
-				return nil, err
-			}
+	for _, provider := range enabled {
+		pullSecretRef, err := provider.PullSecretForImage(image, pod.Namespace, dryRun)
+		if err != nil {
+			slog.Error("failed to create image pull secret", "err", err)
+			return nil, err
+		}
+		if pullSecretRef == nil {
+			continue
 		}
-	}
 
-	if pullSecretRef != nil {
 		patchesContainInitialSecret := lo.ContainsBy(patches, func(patch kubernetes.PatchOperation) bool {
 			return patch.Path == "/spec/imagePullSecrets"
 		})
@@ -137,6 +221,8 @@ func patchesForContainer(
 				})
 			}
 		}
+		// Only the first registry claiming the image gets to add a secret
+		break
 	}
 
 	if len(envVars) > 0 {
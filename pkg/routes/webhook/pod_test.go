@@ -0,0 +1,209 @@
+package webhook
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/magnm/lcm/config"
+	"github.com/magnm/lcm/pkg/kubernetes"
+	"github.com/magnm/lcm/pkg/providers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podWithAnnotations(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", Annotations: annotations}}
+}
+
+func TestProtectOwnedSecret(t *testing.T) {
+	annotation := kubernetes.OwnedSecretAnnotation
+	path := "/metadata/annotations/lcm.io~1owned-secret"
+
+	tests := []struct {
+		name            string
+		pod             map[string]string
+		oldPod          map[string]string
+		created         bool
+		wantPatches     []kubernetes.PatchOperation
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "created without annotation",
+			pod:             map[string]string{"other": "value"},
+			created:         true,
+			wantPatches:     []kubernetes.PatchOperation{},
+			wantAnnotations: map[string]string{"other": "value"},
+		},
+		{
+			name:            "created with annotation",
+			pod:             map[string]string{annotation: "kube-root-ca.crt", "other": "value"},
+			created:         true,
+			wantPatches:     []kubernetes.PatchOperation{{Op: "remove", Path: path}},
+			wantAnnotations: map[string]string{"other": "value"},
+		},
+		{
+			name:            "updated without change",
+			pod:             map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+			oldPod:          map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+			wantPatches:     []kubernetes.PatchOperation{},
+			wantAnnotations: map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+		},
+		{
+			name:            "updated to add annotation",
+			pod:             map[string]string{annotation: "kube-root-ca.crt"},
+			oldPod:          map[string]string{},
+			wantPatches:     []kubernetes.PatchOperation{{Op: "remove", Path: path}},
+			wantAnnotations: map[string]string{},
+		},
+		{
+			name:            "updated to change annotation",
+			pod:             map[string]string{annotation: "kube-root-ca.crt"},
+			oldPod:          map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+			wantPatches:     []kubernetes.PatchOperation{{Op: "add", Path: path, Value: "lcm-owned-aws-credentials-1"}},
+			wantAnnotations: map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+		},
+		{
+			name:            "updated to remove annotation",
+			pod:             map[string]string{"other": "value"},
+			oldPod:          map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+			wantPatches:     []kubernetes.PatchOperation{{Op: "add", Path: path, Value: "lcm-owned-aws-credentials-1"}},
+			wantAnnotations: map[string]string{annotation: "lcm-owned-aws-credentials-1", "other": "value"},
+		},
+		{
+			name:   "updated to remove all annotations",
+			pod:    nil,
+			oldPod: map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+			wantPatches: []kubernetes.PatchOperation{{
+				Op:    "add",
+				Path:  "/metadata/annotations",
+				Value: map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+			}},
+			wantAnnotations: map[string]string{annotation: "lcm-owned-aws-credentials-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := podWithAnnotations(tt.pod)
+			var oldPod *corev1.Pod
+			if !tt.created {
+				oldPod = podWithAnnotations(tt.oldPod)
+			}
+
+			patches := protectOwnedSecret(pod, oldPod)
+			if !reflect.DeepEqual(patches, tt.wantPatches) {
+				t.Errorf("patches = %+v, want %+v", patches, tt.wantPatches)
+			}
+			// Providers see the annotation as lcm set it
+			if !reflect.DeepEqual(pod.Annotations, tt.wantAnnotations) {
+				t.Errorf("annotations = %v, want %v", pod.Annotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+// fakeProvider mutates pods with a fixed mutation.
+type fakeProvider struct {
+	mutation providers.PodMutation
+}
+
+func (p *fakeProvider) Type() config.MetadataType {
+	return "fake"
+}
+
+func (p *fakeProvider) Routes() http.Handler {
+	return http.NotFoundHandler()
+}
+
+func (p *fakeProvider) Matches(r *http.Request) bool {
+	return false
+}
+
+func (p *fakeProvider) MutatePod(pod *corev1.Pod, dryRun bool) (*providers.PodMutation, error) {
+	return &p.mutation, nil
+}
+
+func (p *fakeProvider) PullSecretForImage(image reference.Named, namespace string, dryRun bool) (*corev1.LocalObjectReference, error) {
+	return nil, nil
+}
+
+func (p *fakeProvider) IdentitiesForPod(pod *corev1.Pod) ([]string, error) {
+	return []string{}, nil
+}
+
+func TestMutationForPod(t *testing.T) {
+	google := providers.PodMutation{
+		HostAliases: []corev1.HostAlias{{IP: "10.0.0.10", Hostnames: []string{"metadata.google.internal"}}},
+		EnvVars: []corev1.EnvVar{
+			{Name: "GCE_METADATA_HOST", Value: "metadata.google.internal"},
+			{Name: "SHARED", Value: "google"},
+		},
+	}
+	aws := providers.PodMutation{
+		HostAliases: []corev1.HostAlias{{IP: "10.0.0.10", Hostnames: []string{"instance-data", "metadata.google.internal"}}},
+		EnvVars: []corev1.EnvVar{
+			{Name: "AWS_EC2_METADATA_SERVICE_ENDPOINT", Value: "http://10.0.0.10"},
+			{Name: "SHARED", Value: "aws"},
+		},
+		Annotations: map[string]string{"lcm.io/owned-secret": "lcm-owned-aws-credentials-1"},
+	}
+	other := providers.PodMutation{
+		HostAliases: []corev1.HostAlias{{IP: "10.0.0.11", Hostnames: []string{"other"}}},
+		Annotations: map[string]string{"lcm.io/owned-secret": "lcm-owned-other"},
+	}
+
+	tests := []struct {
+		name      string
+		mutations []providers.PodMutation
+		want      *providers.PodMutation
+	}{
+		{
+			name: "no providers",
+			want: &providers.PodMutation{Annotations: map[string]string{}},
+		},
+		{
+			name:      "single provider",
+			mutations: []providers.PodMutation{google},
+			want: &providers.PodMutation{
+				HostAliases: google.HostAliases,
+				EnvVars:     google.EnvVars,
+				Annotations: map[string]string{},
+			},
+		},
+		{
+			name:      "aliases of the same ip are merged and the first env var and annotation win",
+			mutations: []providers.PodMutation{google, aws, other},
+			want: &providers.PodMutation{
+				HostAliases: []corev1.HostAlias{
+					{IP: "10.0.0.10", Hostnames: []string{"metadata.google.internal", "instance-data"}},
+					{IP: "10.0.0.11", Hostnames: []string{"other"}},
+				},
+				EnvVars: []corev1.EnvVar{
+					{Name: "GCE_METADATA_HOST", Value: "metadata.google.internal"},
+					{Name: "SHARED", Value: "google"},
+					{Name: "AWS_EC2_METADATA_SERVICE_ENDPOINT", Value: "http://10.0.0.10"},
+				},
+				Annotations: map[string]string{"lcm.io/owned-secret": "lcm-owned-aws-credentials-1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled := []providers.Provider{}
+			for _, mutation := range tt.mutations {
+				enabled = append(enabled, &fakeProvider{mutation: mutation})
+			}
+
+			got, err := mutationForPod(enabled, podWithAnnotations(nil), true)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mutationForPod = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
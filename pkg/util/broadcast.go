@@ -0,0 +1,81 @@
+package util
+
+import "sync"
+
+// Broadcaster wakes up every waiter whenever Notify is called.
+type Broadcaster struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{ch: make(chan struct{})}
+}
+
+// Wait returns a channel that is closed on the next call to Notify.
+// Grab the channel before reading the watched state, so no change is missed.
+func (b *Broadcaster) Wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ch
+}
+
+func (b *Broadcaster) Notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
+
+// Topics wakes up the waiters of a topic whenever that topic is notified.
+type Topics struct {
+	mu      sync.Mutex
+	waiters map[string]map[*topicWaiter]struct{}
+}
+
+type topicWaiter struct {
+	ch     chan struct{}
+	topics []string
+}
+
+func NewTopics() *Topics {
+	return &Topics{waiters: map[string]map[*topicWaiter]struct{}{}}
+}
+
+// Wait returns a channel that is closed on the next notification of any of the topics,
+// and a function to stop waiting. Like with Broadcaster, grab the channel before reading the watched state.
+func (t *Topics) Wait(topics ...string) (<-chan struct{}, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	waiter := &topicWaiter{ch: make(chan struct{}), topics: topics}
+	for _, topic := range topics {
+		if t.waiters[topic] == nil {
+			t.waiters[topic] = map[*topicWaiter]struct{}{}
+		}
+		t.waiters[topic][waiter] = struct{}{}
+	}
+	return waiter.ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.remove(waiter)
+	}
+}
+
+func (t *Topics) Notify(topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for waiter := range t.waiters[topic] {
+		t.remove(waiter)
+		close(waiter.ch)
+	}
+}
+
+func (t *Topics) remove(waiter *topicWaiter) {
+	for _, topic := range waiter.topics {
+		delete(t.waiters[topic], waiter)
+		if len(t.waiters[topic]) == 0 {
+			delete(t.waiters, topic)
+		}
+	}
+}
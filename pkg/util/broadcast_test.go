@@ -0,0 +1,78 @@
+package util
+
+import (
+	"testing"
+)
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	first := b.Wait()
+	if isClosed(first) {
+		t.Fatal("channel closed before notify")
+	}
+
+	b.Notify()
+	if !isClosed(first) {
+		t.Fatal("channel not closed after notify")
+	}
+	if second := b.Wait(); isClosed(second) {
+		t.Fatal("channel after notify is already closed")
+	}
+}
+
+func TestTopics(t *testing.T) {
+	tests := []struct {
+		name     string
+		waiting  []string
+		stopped  bool
+		notified string
+		woken    bool
+	}{
+		{name: "notified topic", waiting: []string{"a"}, notified: "a", woken: true},
+		{name: "any of the topics", waiting: []string{"a", "b"}, notified: "b", woken: true},
+		{name: "other topic", waiting: []string{"a"}, notified: "b", woken: false},
+		{name: "no topics", waiting: []string{}, notified: "a", woken: false},
+		{name: "stopped waiting", waiting: []string{"a"}, stopped: true, notified: "a", woken: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topics := NewTopics()
+			changed, stop := topics.Wait(tt.waiting...)
+			if tt.stopped {
+				stop()
+			}
+
+			topics.Notify(tt.notified)
+			if woken := isClosed(changed); woken != tt.woken {
+				t.Errorf("woken = %v, want %v", woken, tt.woken)
+			}
+			stop()
+			if len(topics.waiters) != 0 {
+				t.Errorf("waiters left after stopping: %v", topics.waiters)
+			}
+		})
+	}
+}
+
+func TestTopicsNotifiesOnce(t *testing.T) {
+	topics := NewTopics()
+	changed, stop := topics.Wait("a", "b")
+	defer stop()
+
+	topics.Notify("a")
+	// The waiter is gone after the first notification, so closing again would panic
+	topics.Notify("b")
+	if !isClosed(changed) {
+		t.Fatal("channel not closed after notify")
+	}
+}